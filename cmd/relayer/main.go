@@ -0,0 +1,119 @@
+/*
+ * Daemon running the relayer for the middle-man role, connecting to two
+ * channels through the Fabric Go SDK. With -routes, it runs a router for
+ * multi-hop payments instead, across any number of channels. See the readme
+ * for examples.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ecies"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger/fabric"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/relayer"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to the Fabric SDK connection profile")
+	org := flag.String("org", "", "Organisation of the relaying identity")
+	user := flag.String("user", "", "Relaying identity, which must belong to the handler organisation in both channels")
+	chaincodeID := flag.String("chaincode", "hash-timelock", "Name of the hash timelock chaincode")
+	channelOne := flag.String("channel-one", "", "Name of the first channel")
+	handlerOne := flag.String("handler-one", "", "Handler tagged on proposals in the first channel which are to be relayed")
+	forwardOne := flag.String("forward-one", "", "Handler tagged on proposals relayed into the first channel")
+	channelTwo := flag.String("channel-two", "", "Name of the second channel")
+	handlerTwo := flag.String("handler-two", "", "Handler tagged on proposals in the second channel which are to be relayed")
+	forwardTwo := flag.String("forward-two", "", "Handler tagged on proposals relayed into the second channel")
+	expiryMargin := flag.Duration("expiry-margin", time.Hour, "How long before the original, relayed proposals expire")
+	routes := flag.String("routes", "", "Route multi-hop proposals across these comma separated channel:handler pairs, instead of relaying between two channels")
+	preImageKeyPath := flag.String("pre-image-key", "", "Path to a PEM encoded EC private key, which the pre-images of relayed proposals are encrypted to")
+	flag.Parse()
+
+	var preImageKey *ecdsa.PrivateKey
+	if *preImageKeyPath != "" {
+		keyAsBytes, err := ioutil.ReadFile(*preImageKeyPath)
+		if err != nil {
+			log.Fatalf("Error reading the pre-image key: %s", err)
+		}
+		preImageKey, err = ecies.ParsePrivateKey(keyAsBytes)
+		if err != nil {
+			log.Fatalf("Error parsing the pre-image key: %s", err)
+		}
+	}
+
+	sdk, err := fabsdk.New(config.FromFile(*configPath))
+	if err != nil {
+		log.Fatalf("Error creating the Fabric SDK: %s", err)
+	}
+	defer sdk.Close()
+
+	//Stop cleanly when interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if *routes != "" {
+		channels := []relayer.Channel{}
+		for _, pair := range strings.Split(*routes, ",") {
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid route channel %s, expected channel:handler", pair)
+			}
+			channelLedger, err := fabric.New(sdk, parts[0], *chaincodeID, *org, *user)
+			if err != nil {
+				log.Fatalf("Error connecting to channel %s: %s", parts[0], err)
+			}
+			channels = append(channels, relayer.Channel{Name: parts[0], Ledger: channelLedger, Handler: parts[1], PreImageKey: preImageKey})
+		}
+		router, err := relayer.NewRouter(channels...)
+		if err != nil {
+			log.Fatalf("Error creating the router: %s", err)
+		}
+		log.Printf("Routing across channels %s", *routes)
+		err = router.Run(ctx)
+		if err != nil && err != context.Canceled {
+			log.Fatalf("Router stopped: %s", err)
+		}
+		return
+	}
+
+	ledgerOne, err := fabric.New(sdk, *channelOne, *chaincodeID, *org, *user)
+	if err != nil {
+		log.Fatalf("Error connecting to channel %s: %s", *channelOne, err)
+	}
+	ledgerTwo, err := fabric.New(sdk, *channelTwo, *chaincodeID, *org, *user)
+	if err != nil {
+		log.Fatalf("Error connecting to channel %s: %s", *channelTwo, err)
+	}
+	r, err := relayer.New(
+		relayer.Channel{Name: *channelOne, Ledger: ledgerOne, Handler: *handlerOne, ForwardHandler: *forwardOne, PreImageKey: preImageKey},
+		relayer.Channel{Name: *channelTwo, Ledger: ledgerTwo, Handler: *handlerTwo, ForwardHandler: *forwardTwo, PreImageKey: preImageKey},
+		*expiryMargin,
+	)
+	if err != nil {
+		log.Fatalf("Error creating the relayer: %s", err)
+	}
+
+	log.Printf("Relaying between channels %s and %s", *channelOne, *channelTwo)
+	err = r.Run(ctx)
+	if err != nil && err != context.Canceled {
+		log.Fatalf("Relayer stopped: %s", err)
+	}
+}
@@ -0,0 +1,70 @@
+/*
+ * Daemon running the timeout service for a channel, connecting through the
+ * Fabric Go SDK. See the readme for an example.
+ */
+
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger/fabric"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/timeout"
+	"github.com/hyperledger/fabric-sdk-go/pkg/core/config"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "Path to the Fabric SDK connection profile")
+	org := flag.String("org", "", "Organisation of the timeout service identity")
+	user := flag.String("user", "", "Timeout service identity, which must be allowed to invalidate by the access policy")
+	chaincodeID := flag.String("chaincode", "hash-timelock", "Name of the hash timelock chaincode")
+	channelID := flag.String("channel", "", "Name of the channel to watch")
+	schedulePath := flag.String("schedule", "timeout-schedule.json", "Path to the file holding the schedule of deadlines")
+	grace := flag.Duration("grace", 5*time.Minute, "Delay after expiry before invalidating, at least the contract clock skew tolerance")
+	jitter := flag.Duration("jitter", 30*time.Second, "Maximum random delay added to each deadline")
+	retries := flag.Int("retries", 3, "Number of retries when invalidation hits a read conflict")
+	retryDelay := flag.Duration("retry-delay", 2*time.Second, "Delay between retries")
+	pollInterval := flag.Duration("poll-interval", 10*time.Second, "How often deadlines are checked")
+	flag.Parse()
+
+	sdk, err := fabsdk.New(config.FromFile(*configPath))
+	if err != nil {
+		log.Fatalf("Error creating the Fabric SDK: %s", err)
+	}
+	defer sdk.Close()
+	channelLedger, err := fabric.New(sdk, *channelID, *chaincodeID, *org, *user)
+	if err != nil {
+		log.Fatalf("Error connecting to channel %s: %s", *channelID, err)
+	}
+	watcher, err := timeout.New(channelLedger, timeout.FileStore{Path: *schedulePath}, timeout.Config{
+		Grace:        *grace,
+		Jitter:       *jitter,
+		Retries:      *retries,
+		RetryDelay:   *retryDelay,
+		PollInterval: *pollInterval,
+	})
+	if err != nil {
+		log.Fatalf("Error creating the timeout watcher: %s", err)
+	}
+
+	//Stop cleanly when interrupted
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+	log.Printf("Watching for timeouts on channel %s", *channelID)
+	err = watcher.Run(ctx)
+	if err != nil && err != context.Canceled {
+		log.Fatalf("Timeout watcher stopped: %s", err)
+	}
+}
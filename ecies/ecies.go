@@ -0,0 +1,225 @@
+/*
+ * Encryption of pre-images to the middle-man. A proposal can name the public
+ * key of the identity which should receive its pre-image, in which case the
+ * CONFIRMATION event, and the proposal, carry it encrypted to that key rather
+ * than in clear, so only the intended middle-man can race to claim the other
+ * leg of the swap.
+ *
+ * This is ECIES on the curve of the Fabric identity, P-256 or P-384. The
+ * ciphertext is the uncompressed ephemeral public key, followed by the
+ * pre-image sealed with AES-256-GCM, under a key derived from the shared
+ * secret with the ANSI X9.63 KDF over SHA-256.
+ *
+ * Every endorser must produce the same ciphertext, so the ephemeral key is
+ * derived from the pre-image and a nonce, such as the transaction id, rather
+ * than drawn at random. Anyone able to derive it must already know the
+ * pre-image, which is committed to by its hash in any case, so this doesn't
+ * weaken the encryption. As each key is only used once, the GCM nonce is
+ * fixed.
+ */
+
+package ecies
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+//Encoding is the pre-image encoding reported for encrypted pre-images, which
+//are the base64 of the ciphertext
+const Encoding = "ecies"
+
+//Encrypt encrypts the plaintext to the public key. The nonce distinguishes
+//encryptions of the same plaintext to the same key, the same nonce always
+//giving the same ciphertext.
+func Encrypt(pub *ecdsa.PublicKey, plaintext []byte, nonce []byte) ([]byte, error) {
+	err := checkCurve(pub.Curve)
+	if err != nil {
+		return nil, err
+	}
+	k := ephemeralKey(pub, plaintext, nonce)
+	rx, ry := pub.Curve.ScalarBaseMult(k)
+	ephemeral := elliptic.Marshal(pub.Curve, rx, ry)
+	sx, _ := pub.Curve.ScalarMult(pub.X, pub.Y, k)
+	aead, err := newAEAD(pub.Curve, sx, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(ephemeral, make([]byte, aead.NonceSize()), plaintext, nil), nil
+}
+
+//Decrypt decrypts a ciphertext produced by Encrypt with the private key
+func Decrypt(key *ecdsa.PrivateKey, ciphertext []byte) ([]byte, error) {
+	err := checkCurve(key.Curve)
+	if err != nil {
+		return nil, err
+	}
+	pointLength := 1 + 2*byteLength(key.Curve)
+	if len(ciphertext) < pointLength {
+		return nil, errors.New("The ciphertext is too short.")
+	}
+	ephemeral := ciphertext[:pointLength]
+	rx, ry := elliptic.Unmarshal(key.Curve, ephemeral)
+	if rx == nil {
+		return nil, errors.New("The ciphertext doesn't start with a point on the curve of the key.")
+	}
+	sx, _ := key.Curve.ScalarMult(rx, ry, key.D.Bytes())
+	aead, err := newAEAD(key.Curve, sx, ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, make([]byte, aead.NonceSize()), ciphertext[pointLength:], nil)
+	if err != nil {
+		return nil, errors.New("The ciphertext couldn't be decrypted with the key.")
+	}
+	return plaintext, nil
+}
+
+//Reveal decrypts a pre-image reported with the Encoding, giving it in base64
+//so that it can be replayed with confirmProposal. Pre-images with any other
+//encoding are returned unchanged, so this can be applied to every pre-image
+//received.
+func Reveal(key *ecdsa.PrivateKey, preImage string, encoding string) (string, string, error) {
+	if encoding != Encoding {
+		return preImage, encoding, nil
+	}
+	if key == nil {
+		return "", "", errors.New("The pre-image is encrypted, but no key was provided to decrypt it.")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(preImage)
+	if err != nil {
+		return "", "", fmt.Errorf("Error decoding the encrypted pre-image - %s", err.Error())
+	}
+	plaintext, err := Decrypt(key, ciphertext)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(plaintext), "base64", nil
+}
+
+//ParsePublicKey parses a PEM encoded public key, or the certificate of a
+//Fabric identity, which must be on a supported curve
+func ParsePublicKey(pemBytes []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("No PEM encoded key or certificate was found.")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Expected a PUBLIC KEY or CERTIFICATE, but found a %s.", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the public key - %s", err.Error())
+	}
+	pub, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("The public key must be an ECDSA key.")
+	}
+	return pub, checkCurve(pub.Curve)
+}
+
+//MarshalPublicKey PEM encodes a public key, as accepted by ParsePublicKey
+func MarshalPublicKey(pub *ecdsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding the public key - %s", err.Error())
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+//ParsePrivateKey parses a PEM encoded private key, in PKCS#8 as found in the
+//keystore of a Fabric MSP, or SEC 1 form
+func ParsePrivateKey(pemBytes []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, errors.New("No PEM encoded key was found.")
+	}
+	if block.Type == "EC PRIVATE KEY" {
+		key, err := x509.ParseECPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the private key - %s", err.Error())
+		}
+		return key, checkCurve(key.Curve)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing the private key - %s", err.Error())
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("The private key must be an ECDSA key.")
+	}
+	return key, checkCurve(key.Curve)
+}
+
+//checkCurve refuses keys on curves other than those used by Fabric
+func checkCurve(curve elliptic.Curve) error {
+	if curve != elliptic.P256() && curve != elliptic.P384() {
+		return errors.New("Only keys on the P-256 and P-384 curves are supported.")
+	}
+	return nil
+}
+
+//byteLength is the length of a coordinate on the curve, in bytes
+func byteLength(curve elliptic.Curve) int {
+	return (curve.Params().BitSize + 7) / 8
+}
+
+//ephemeralKey derives the ephemeral private key for an encryption from the
+//plaintext, the nonce and the recipient. The digest is twice the length of
+//the curve order, so reducing it leaves no meaningful bias.
+func ephemeralKey(pub *ecdsa.PublicKey, plaintext []byte, nonce []byte) []byte {
+	recipient := elliptic.Marshal(pub.Curve, pub.X, pub.Y)
+	for counter := byte(0); ; counter++ {
+		mac := hmac.New(sha512.New, plaintext)
+		mac.Write(nonce)
+		mac.Write(recipient)
+		mac.Write([]byte{counter})
+		k := new(big.Int).SetBytes(mac.Sum(nil))
+		k.Mod(k, pub.Curve.Params().N)
+		if k.Sign() != 0 {
+			return k.Bytes()
+		}
+	}
+}
+
+//newAEAD derives the AES-256-GCM key from the x coordinate of the shared
+//point, with the ephemeral public key as shared info
+func newAEAD(curve elliptic.Curve, sx *big.Int, ephemeral []byte) (cipher.AEAD, error) {
+	shared := make([]byte, byteLength(curve))
+	sxAsBytes := sx.Bytes()
+	copy(shared[len(shared)-len(sxAsBytes):], sxAsBytes)
+	counter := make([]byte, 4)
+	binary.BigEndian.PutUint32(counter, 1)
+	kdf := sha256.New()
+	kdf.Write(shared)
+	kdf.Write(counter)
+	kdf.Write(ephemeral)
+	block, err := aes.NewCipher(kdf.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
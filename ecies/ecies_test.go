@@ -0,0 +1,112 @@
+package ecies
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func newTestKey(t *testing.T, curve elliptic.Curve) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key - %s", err.Error())
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	for _, curve := range []elliptic.Curve{elliptic.P256(), elliptic.P384()} {
+		key := newTestKey(t, curve)
+		ciphertext, err := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid1"))
+		if err != nil {
+			t.Fatalf("Error encrypting on %s - %s", curve.Params().Name, err.Error())
+		}
+		plaintext, err := Decrypt(key, ciphertext)
+		if err != nil {
+			t.Fatalf("Error decrypting on %s - %s", curve.Params().Name, err.Error())
+		}
+		if string(plaintext) != "test_hash" {
+			t.Errorf("Decrypted %s on %s, but expected test_hash.", plaintext, curve.Params().Name)
+		}
+	}
+}
+
+func TestEncryptIsDeterministic(t *testing.T) {
+	key := newTestKey(t, elliptic.P256())
+	first, err := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid1"))
+	if err != nil {
+		t.Fatalf("Error encrypting - %s", err.Error())
+	}
+	second, _ := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid1"))
+	if !bytes.Equal(first, second) {
+		t.Error("Encrypting the same pre-image with the same nonce gave different ciphertexts.")
+	}
+	third, _ := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid2"))
+	if bytes.Equal(first, third) {
+		t.Error("Encrypting with a different nonce gave the same ciphertext.")
+	}
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	key := newTestKey(t, elliptic.P256())
+	ciphertext, _ := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid1"))
+	_, err := Decrypt(newTestKey(t, elliptic.P256()), ciphertext)
+	if err == nil {
+		t.Error("Decrypting with another key succeeded, but expected an error.")
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	_, err = Decrypt(key, ciphertext)
+	if err == nil {
+		t.Error("Decrypting a tampered ciphertext succeeded, but expected an error.")
+	}
+}
+
+func TestUnsupportedCurve(t *testing.T) {
+	key := newTestKey(t, elliptic.P521())
+	_, err := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid1"))
+	if err == nil {
+		t.Error("Encrypting to a P-521 key succeeded, but expected an error.")
+	}
+}
+
+func TestPublicKeyRoundTrip(t *testing.T) {
+	key := newTestKey(t, elliptic.P256())
+	encoded, err := MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Error marshaling the public key - %s", err.Error())
+	}
+	pub, err := ParsePublicKey([]byte(encoded))
+	if err != nil {
+		t.Fatalf("Error parsing the public key - %s", err.Error())
+	}
+	if pub.X.Cmp(key.X) != 0 || pub.Y.Cmp(key.Y) != 0 {
+		t.Error("The parsed public key doesn't match the marshaled key.")
+	}
+	_, err = ParsePublicKey([]byte("not a key"))
+	if err == nil {
+		t.Error("Parsing an invalid public key succeeded, but expected an error.")
+	}
+}
+
+func TestReveal(t *testing.T) {
+	key := newTestKey(t, elliptic.P256())
+	ciphertext, _ := Encrypt(&key.PublicKey, []byte("test_hash"), []byte("txid1"))
+	preImage, encoding, err := Reveal(key, base64.StdEncoding.EncodeToString(ciphertext), Encoding)
+	if err != nil {
+		t.Fatalf("Error revealing the pre-image - %s", err.Error())
+	}
+	if encoding != "base64" || preImage != base64.StdEncoding.EncodeToString([]byte("test_hash")) {
+		t.Errorf("Revealed %s with encoding %s, but expected test_hash in base64.", preImage, encoding)
+	}
+	preImage, encoding, err = Reveal(nil, "test_hash", "")
+	if err != nil || preImage != "test_hash" || encoding != "" {
+		t.Errorf("Revealing a clear pre-image gave %s with encoding %s, but expected it unchanged.", preImage, encoding)
+	}
+	_, _, err = Reveal(nil, base64.StdEncoding.EncodeToString(ciphertext), Encoding)
+	if err == nil {
+		t.Error("Revealing an encrypted pre-image without a key succeeded, but expected an error.")
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Events fired by the contract. Each transaction fires one Name event, with
+ * an Envelope payload:
+ *
+ *   {"events": [{"type": "HANDLER_NOTIFICATION", "proposalId": "prop1234",
+ *                "proposalHandler": "Bob", "expiry": 1500086400}, ...]}
+ *
+ * The contract builds its events from these types, and listeners should
+ * decode the payload with Decode, then pick out the sub-events they are
+ * interested in, e.g. by handler or type.
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//Name is the name of the single event fired by each transaction. Fabric
+//only keeps the last event set in a transaction, so everything which
+//happened is carried as a list of typed sub-events in an Envelope.
+const Name = "PROPOSAL_EVENTS"
+
+//Types of the sub-events carried in an Envelope
+
+//HandlerNotification is fired when a proposal is created, to inform the
+//intended handler, which is named in the sub-event
+const HandlerNotification = "HANDLER_NOTIFICATION"
+
+//TimeoutRegistration is fired when a proposal is created, it is intended to
+//be handled by a client which makes an invalidate call once the expiry
+//recorded against the proposal has passed.
+const TimeoutRegistration = "TIMEOUT_REGISTRATION"
+
+//Acceptance is fired when the handler accepts a proposal, to let its creator
+//know it has been taken on
+const Acceptance = "ACCEPTANCE"
+
+//Confirmation is fired when a proposal is confirmed, and carries the
+//pre-image, to allow the middle-man to replay it into the other channel
+const Confirmation = "CONFIRMATION"
+
+//Invalidation is fired when a proposal is invalidated after its timelock
+//expired, so that relayers can unwind the other leg of the swap
+const Invalidation = "INVALIDATION"
+
+//Rejection is fired when the handler rejects a proposal, so that relayers
+//can unwind the other leg of the swap
+const Rejection = "REJECTION"
+
+//Pause is fired when the admins pause the contract, so that relayers stop
+//creating proposals for the other leg of swaps in the channel
+const Pause = "PAUSE"
+
+//Resume is fired when a paused contract is resumed
+const Resume = "RESUME"
+
+//Freeze is fired when the admins freeze the proposals of the handler named
+//in the sub-event
+const Freeze = "FREEZE"
+
+//Unfreeze is fired when the proposals of a frozen handler are unfrozen
+const Unfreeze = "UNFREEZE"
+
+//Envelope is the payload of the Name event, holding every sub-event fired by
+//the transaction, in the order they were raised
+type Envelope struct {
+	Events []Event `json:"events"`
+}
+
+//Event is a single typed sub-event. Handler is the handler tagged on the
+//proposal, Expiry is set for HANDLER_NOTIFICATION and TIMEOUT_REGISTRATION
+//events and PreImage, with its PreImageEncoding, for CONFIRMATION events.
+//Replaying the pre-image with the same encoding supplies the same bytes.
+//INVALIDATION and REJECTION events carry the Hash, the Reason, the Channel
+//the proposal was held in and the Timestamp (unix seconds) of the
+//transaction, for unwinding the other leg. The PAUSE, RESUME, FREEZE and
+//UNFREEZE events don't refer to a proposal, but carry the Reason, Channel and
+//Timestamp, with the Handler for freezes, and whether a PAUSE also has
+//BlockConfirmations.
+type Event struct {
+	Type               string `json:"type"`
+	ProposalID         string `json:"proposalId"`
+	Handler            string `json:"proposalHandler"`
+	Expiry             int64  `json:"expiry,omitempty"`
+	PreImage           string `json:"preImage,omitempty"`
+	PreImageEncoding   string `json:"preImageEncoding,omitempty"`
+	Hash               string `json:"hash,omitempty"`
+	Reason             string `json:"reason,omitempty"`
+	Channel            string `json:"channel,omitempty"`
+	Timestamp          int64  `json:"timestamp,omitempty"`
+	BlockConfirmations bool   `json:"blockConfirmations,omitempty"`
+}
+
+//Decode parses the payload of a Name event
+func Decode(payload []byte) (Envelope, error) {
+	envelope := Envelope{}
+	err := json.Unmarshal(payload, &envelope)
+	if err != nil {
+		return envelope, fmt.Errorf("Error parsing proposal event - %s", err.Error())
+	}
+	return envelope, nil
+}
+
+//ForHandler returns the sub-events for proposals tagged with the handler
+func (envelope Envelope) ForHandler(handler string) []Event {
+	matched := []Event{}
+	for _, event := range envelope.Events {
+		if event.Handler == handler {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
+
+//OfType returns the sub-events of the given type
+func (envelope Envelope) OfType(eventType string) []Event {
+	matched := []Event{}
+	for _, event := range envelope.Events {
+		if event.Type == eventType {
+			matched = append(matched, event)
+		}
+	}
+	return matched
+}
@@ -0,0 +1,37 @@
+package events
+
+import (
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	payload := "{\"events\":[" +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500086400}," +
+		"{\"type\":\"TIMEOUT_REGISTRATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500086400}," +
+		"{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop2\",\"proposalHandler\":\"Charlie\",\"preImage\":\"test_hash\"}]}"
+	envelope, err := Decode([]byte(payload))
+	if err != nil {
+		t.Fatalf("Error decoding proposal events - %s", err.Error())
+	}
+	if len(envelope.Events) != 3 {
+		t.Errorf("Decoded %d events, but expected %d.", len(envelope.Events), 3)
+	}
+	bobEvents := envelope.ForHandler("Bob")
+	if len(bobEvents) != 2 || bobEvents[0].Type != HandlerNotification || bobEvents[1].Type != TimeoutRegistration {
+		t.Errorf("Events for Bob were %v, but expected the handler notification and timeout registration.", bobEvents)
+	}
+	confirmations := envelope.OfType(Confirmation)
+	if len(confirmations) != 1 || confirmations[0].ProposalID != "prop2" || confirmations[0].PreImage != "test_hash" {
+		t.Errorf("Confirmation events were %v, but expected the confirmation of prop2.", confirmations)
+	}
+	if len(envelope.ForHandler("Alice")) != 0 {
+		t.Error("Found events for Alice, but expected none.")
+	}
+}
+
+func TestDecodeInvalidPayload(t *testing.T) {
+	_, err := Decode([]byte("PROPOSAL_CREATED"))
+	if err == nil {
+		t.Error("Decoding an invalid payload succeeded, but expected an error.")
+	}
+}
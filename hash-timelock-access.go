@@ -0,0 +1,130 @@
+/*
+ * Identity based access control. The identity of the creator of each
+ * transaction is taken from their certificate, then checked against the
+ * accessPolicy held in the contract configuration for this channel.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/attrmgr"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+)
+
+//getClientIdentity retrieves the identity of the transaction creator, with
+//the attributes from their certificate
+func getClientIdentity(stub shim.ChaincodeStubInterface) (clientIdentity, error) {
+	identity := clientIdentity{}
+	client, err := cid.New(stub)
+	if err != nil {
+		return identity, fmt.Errorf("Error retreiving the identity of the transaction creator - %s", err.Error())
+	}
+	identity.MSPID, err = client.GetMSPID()
+	if err != nil {
+		return identity, fmt.Errorf("Error retreiving the MSP of the transaction creator - %s", err.Error())
+	}
+	cert, err := client.GetX509Certificate()
+	if err != nil {
+		return identity, fmt.Errorf("Error retreiving the certificate of the transaction creator - %s", err.Error())
+	}
+	//Idemix identities have no certificate, so can't be uniquely identified
+	if cert == nil {
+		return identity, nil
+	}
+	identity.ID, err = client.GetID()
+	if err != nil {
+		return identity, fmt.Errorf("Error retreiving the id of the transaction creator - %s", err.Error())
+	}
+	attrs, err := attrmgr.New().GetAttributesFromCert(cert)
+	if err != nil {
+		return identity, fmt.Errorf("Error retreiving the attributes of the transaction creator - %s", err.Error())
+	}
+	if attrs != nil && len(attrs.Attrs) > 0 {
+		identity.Attributes = attrs.Attrs
+	}
+	return identity, nil
+}
+
+//isSameIdentity checks whether two identities refer to the same client,
+//identities without an id can't be matched
+func isSameIdentity(a *clientIdentity, b clientIdentity) bool {
+	return a != nil && a.ID != "" && a.MSPID == b.MSPID && a.ID == b.ID
+}
+
+//matches checks whether the identity is from the MSP of the matcher, and
+//holds the attribute value if one is required
+func (matcher identityMatcher) matches(identity clientIdentity) bool {
+	if matcher.MSPID != identity.MSPID {
+		return false
+	}
+	if matcher.Attribute == "" {
+		return true
+	}
+	value, ok := identity.Attributes[matcher.Attribute]
+	return ok && value == matcher.Value
+}
+
+//canCreate checks whether the identity is permitted to create proposals
+func (policy accessPolicy) canCreate(identity clientIdentity) bool {
+	if len(policy.CreatorMSPs) == 0 {
+		return true
+	}
+	for _, mspID := range policy.CreatorMSPs {
+		if mspID == identity.MSPID {
+			return true
+		}
+	}
+	return false
+}
+
+//canConfirm checks whether the identity belongs to the organisation of the
+//handler tagged on the proposal
+func (policy accessPolicy) canConfirm(identity clientIdentity, proposal proposalEntry) bool {
+	return identity.MSPID == policy.handlerMSP(proposal.Proposal.Handler)
+}
+
+//handlerMSP gives the MSP of the organisation of a handler
+func (policy accessPolicy) handlerMSP(handler string) string {
+	handlerMSP, ok := policy.HandlerMSPs[handler]
+	if !ok {
+		return handler
+	}
+	return handlerMSP
+}
+
+//canInvalidate checks whether the identity either created the proposal, or
+//is one of the configured timeout services
+func (policy accessPolicy) canInvalidate(identity clientIdentity, proposal proposalEntry) bool {
+	if isSameIdentity(proposal.Creator, identity) {
+		return true
+	}
+	for _, service := range policy.TimeoutServices {
+		if service.matches(identity) {
+			return true
+		}
+	}
+	return false
+}
+
+//canMint checks whether the identity is one of the configured minters
+func (policy accessPolicy) canMint(identity clientIdentity) bool {
+	for _, minter := range policy.Minters {
+		if minter.matches(identity) {
+			return true
+		}
+	}
+	return false
+}
+
+//canAdminister checks whether the identity is one of the configured admins
+func (policy accessPolicy) canAdminister(identity clientIdentity) bool {
+	for _, admin := range policy.Admins {
+		if admin.matches(identity) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+)
+
+//createTestProposal creates a proposal for Bob as Alice, which expires an
+//hour after the test time
+func createTestProposal(t *testing.T, stub *testStub) {
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	hash := "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA256"), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestCreateProposalNotPermitted(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"creatorMSPs\":[\"Alice\"]}}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Mallory", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
+	res = stub.MockInvoke("txid1", args)
+	if res.Status != 500 {
+		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "The transaction creator is not permitted to create proposals."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	//Listed MSPs can still create
+	createTestProposal(t, stub)
+}
+
+func TestCreateProposalRetryNotPermitted(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"creatorMSPs\":[\"Alice\"]}}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	createTestProposal(t, stub)
+	//Retrying an existing proposal doesn't get around the policy
+	stub.Creator = newTestIdentity(t, "Mallory", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"1h\",\"retry\":true}")}
+	res = stub.MockInvoke("txid2", args)
+	expectedMessage := "The transaction creator is not permitted to create proposals."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestCreateProposalRecordsAttributes(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", map[string]string{"role": "trader"})
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"" + testHashes["SHA512"] + "\",\"hashAlgorithm\":\"SHA512\",\"expiry\":1500086400," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\",\"attributes\":{\"role\":\"trader\"}}}"
+	proposal, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if string(proposal) != expectedRes {
+		t.Errorf("Create proposal stored %s, but expected: %s.", string(proposal), expectedRes)
+	}
+}
+
+func TestConfirmProposalWrongOrganisation(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	stub.Creator = newTestIdentity(t, "Charlie", nil)
+	args := [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Confirm Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "Only the organisation of the proposal handler can confirm this proposal."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestConfirmProposalMappedHandler(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"handlerMSPs\":{\"Bob\":\"BobMSP\"}}}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	createTestProposal(t, stub)
+	//The handler name itself is no longer accepted as the MSP
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Confirm Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	stub.Creator = newTestIdentity(t, "BobMSP", nil)
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestInvalidateProposalNotCreator(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	//Even the handler can't invalidate a proposal it didn't create
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Invalidate Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "Only the proposal creator or a timeout service can invalidate this proposal."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestInvalidateProposalByTimeoutService(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"timeoutServices\":" +
+		"[{\"mspId\":\"Ops\",\"attribute\":\"role\",\"value\":\"timeout\"}]}}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	createTestProposal(t, stub)
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	//Identities from the MSP without the attribute are refused
+	stub.Creator = newTestIdentity(t, "Ops", map[string]string{"role": "admin"})
+	args := [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Invalidate Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	stub.Creator = newTestIdentity(t, "Ops", map[string]string{"role": "timeout"})
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
@@ -0,0 +1,190 @@
+/*
+ * Registry of non-fungible assets, so that a unique asset can be swapped
+ * against a token payment in another channel (delivery-versus-payment). Each
+ * asset is owned by an account, which as for tokens is the MSP of an
+ * organisation.
+ *
+ * A proposal with an assetId locks the asset when it is created, so that it
+ * can't be transferred while the proposal is pending. Confirming the proposal
+ * transfers the asset to the beneficiary, which defaults to the organisation
+ * of the handler, while invalidating or rejecting it unlocks the asset for
+ * its owner.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Registers a new asset - takes the assetId and the account which owns it.
+ * Only the minters in the access policy can create assets.
+ */
+func (s *HashTimeLockContract) createAsset(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 2, the asset id and the owner
+	if len(args) != 2 {
+		return shim.Error("Invalid arguments to createAsset, expected assetId and owner.")
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("An assetId and owner must be provided.")
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canMint(caller) {
+		return shim.Error("The transaction creator is not permitted to create assets.")
+	}
+	existing, err := getAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if existing != nil {
+		return shim.Error("An asset with this assetId already exists.")
+	}
+	err = putAsset(stub, assetRecord{AssetID: args[0], Owner: args[1]})
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Transfers an asset owned by the organisation of the transaction creator -
+ * takes the assetId and the account to transfer it to. Assets locked by a
+ * pending proposal can't be transferred.
+ */
+func (s *HashTimeLockContract) transferAsset(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 2, the asset id and the recipient
+	if len(args) != 2 {
+		return shim.Error("Invalid arguments to transferAsset, expected assetId and recipient.")
+	}
+	if args[1] == "" {
+		return shim.Error("A recipient must be provided.")
+	}
+	asset, err := getAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("No such asset.")
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset.Owner != caller.MSPID {
+		return shim.Error("Only the owner can transfer this asset.")
+	}
+	if asset.LockedBy != "" {
+		return shim.Error(errAssetLocked(*asset).Error())
+	}
+	asset.Owner = args[1]
+	err = putAsset(stub, *asset)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Returns an asset, as a JSON assetRecord, showing its owner and the proposal
+ * locking it, if any.
+ */
+func (s *HashTimeLockContract) ownerOf(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 1, the asset id
+	if len(args) != 1 {
+		return shim.Error("Invalid arguments to ownerOf, expected assetId.")
+	}
+	asset, err := getAsset(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if asset == nil {
+		return shim.Error("No such asset.")
+	}
+	assetAsBytes, err := json.Marshal(asset)
+	if err != nil {
+		return shim.Error("Error building asset - " + err.Error())
+	}
+	return shim.Success(assetAsBytes)
+}
+
+//lockAsset locks an asset owned by the account against a new proposal
+func lockAsset(stub shim.ChaincodeStubInterface, assetID string, owner string, proposalID string) error {
+	asset, err := getAsset(stub, assetID)
+	if err != nil {
+		return err
+	}
+	if asset == nil {
+		return codedError{ErrInvalidProposal, "No asset exists with the assetId " + assetID + "."}
+	}
+	if asset.Owner != owner {
+		return codedError{ErrInvalidProposal, "The asset must be owned by the organisation of the transaction creator."}
+	}
+	if asset.LockedBy != "" {
+		return errAssetLocked(*asset)
+	}
+	asset.LockedBy = proposalID
+	return putAsset(stub, *asset)
+}
+
+//unlockAsset releases an asset from its proposal, passing it to the owner,
+//who is the beneficiary on confirmation, or the original owner on refund
+func unlockAsset(stub shim.ChaincodeStubInterface, assetID string, owner string) error {
+	asset, err := getAsset(stub, assetID)
+	if err != nil {
+		return err
+	}
+	if asset == nil {
+		return fmt.Errorf("The asset %s locked by the proposal no longer exists.", assetID)
+	}
+	asset.Owner = owner
+	asset.LockedBy = ""
+	return putAsset(stub, *asset)
+}
+
+//getAsset reads an asset from state, returning nil if it doesn't exist
+func getAsset(stub shim.ChaincodeStubInterface, assetID string) (*assetRecord, error) {
+	assetAsBytes, err := stub.GetState(assetPrefix + assetID)
+	if err != nil {
+		return nil, fmt.Errorf("Error retreiving asset from state - %s", err.Error())
+	}
+	if assetAsBytes == nil {
+		return nil, nil
+	}
+	asset := &assetRecord{}
+	err = json.Unmarshal(assetAsBytes, asset)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing asset stored in state - %s", err.Error())
+	}
+	return asset, nil
+}
+
+//putAsset writes an asset to state, under its assetId
+func putAsset(stub shim.ChaincodeStubInterface, asset assetRecord) error {
+	assetAsBytes, err := json.Marshal(asset)
+	if err != nil {
+		return fmt.Errorf("Error building asset - %s", err.Error())
+	}
+	err = stub.PutState(assetPrefix+asset.AssetID, assetAsBytes)
+	if err != nil {
+		return fmt.Errorf("Error writing asset to state - %s", err.Error())
+	}
+	return nil
+}
+
+//errAssetLocked is the error for an asset which can't be moved, as it is
+//locked by a pending proposal
+func errAssetLocked(asset assetRecord) error {
+	return codedError{ErrAssetLocked, fmt.Sprintf("The asset %s is locked by proposal %s.", asset.AssetID, asset.LockedBy)}
+}
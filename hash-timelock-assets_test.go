@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+)
+
+func createTestAsset(t *testing.T, stub *testStub, assetID string, owner string) {
+	stub.Creator = newTestIdentity(t, "Ops", nil)
+	res := stub.MockInvoke("txid-asset", [][]byte{[]byte("createAsset"), []byte(assetID), []byte(owner)})
+	if res.Status != 200 {
+		t.Fatalf("Create Asset returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+}
+
+//checkTestAsset checks the owner of an asset, and the proposal locking it
+func checkTestAsset(t *testing.T, stub *testStub, assetID string, owner string, lockedBy string) {
+	res := stub.MockInvoke("txid-owner", [][]byte{[]byte("ownerOf"), []byte(assetID)})
+	if res.Status != 200 {
+		t.Fatalf("Owner Of returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	asset := assetRecord{}
+	err := json.Unmarshal(res.Payload, &asset)
+	if err != nil {
+		t.Fatalf("Error parsing asset - %s", err.Error())
+	}
+	expected := assetRecord{AssetID: assetID, Owner: owner, LockedBy: lockedBy}
+	if asset != expected {
+		t.Errorf("Owner of returned %+v in %s, but expected %+v.", asset, stub.ChannelID, expected)
+	}
+}
+
+func TestCreateAndTransferAsset(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	createTestAsset(t, stub, "painting", "Alice")
+	checkTestAsset(t, stub, "painting", "Alice", "")
+
+	//Only minters can create assets, and each assetId only once
+	res := stub.MockInvoke("txid1", [][]byte{[]byte("createAsset"), []byte("painting"), []byte("Ops")})
+	if res.Status != 500 || res.Message != "An asset with this assetId already exists." {
+		t.Errorf("Create Asset returned status %d and error: %s, but expected the duplicate to be refused.", res.Status, res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("createAsset"), []byte("sculpture"), []byte("Alice")})
+	if res.Status != 500 || res.Message != "The transaction creator is not permitted to create assets." {
+		t.Errorf("Create Asset returned status %d and error: %s, but expected Alice to be refused.", res.Status, res.Message)
+	}
+
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("transferAsset"), []byte("painting"), []byte("Charlie")})
+	if res.Status != 200 {
+		t.Errorf("Transfer Asset returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	checkTestAsset(t, stub, "painting", "Charlie", "")
+
+	//Alice no longer owns it
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("transferAsset"), []byte("painting"), []byte("Alice")})
+	if res.Status != 500 || res.Message != "Only the owner can transfer this asset." {
+		t.Errorf("Transfer Asset returned status %d and error: %s, but expected Alice to be refused.", res.Status, res.Message)
+	}
+}
+
+func TestAssetLockedByPendingProposal(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	createTestAsset(t, stub, "painting", "Alice")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"assetId\":\"painting\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	nextTestEvent(t, stub)
+	checkTestAsset(t, stub, "painting", "Alice", "prop1234")
+
+	//The asset can't be moved, or offered again, until the proposal settles
+	expectedMessage := ErrAssetLocked + ": The asset painting is locked by proposal prop1234."
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("transferAsset"), []byte("painting"), []byte("Charlie")})
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Transfer Asset returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	testProposal = "{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Charlie\",\"assetId\":\"painting\"}"
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+
+	//Once the proposal times out, the asset is unlocked for Alice
+	stub.TxTime = testTime + defaultTimelock + defaultClockSkewTolerance + 1
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("invalidateProposal"), []byte("prop1234")})
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	checkTestAsset(t, stub, "painting", "Alice", "")
+}
+
+func TestCreateProposalForUnownedAsset(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	createTestAsset(t, stub, "painting", "Charlie")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"assetId\":\"painting\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	expectedMessage := ErrInvalidProposal + ": The asset must be owned by the organisation of the transaction creator."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	checkTestAsset(t, stub, "painting", "Charlie", "")
+}
+
+func TestCrossChannelDeliveryVersusPayment(t *testing.T) {
+	//Alice sells her painting in channel one to Bob, who pays 100 GBP in
+	//channel two
+	channelOne := newTestTokenStub(t, "channelOne")
+	createTestAsset(t, channelOne, "painting", "Alice")
+	channelTwo := newTestTokenStub(t, "channelTwo")
+	mintTestTokens(t, channelTwo, "Bob", "100")
+
+	//Bob alone knows the pre-image, and locks his payment to Alice first, with
+	//the longer timelock
+	channelTwo.Creator = newTestIdentity(t, "Bob", nil)
+	testProposal := "{\"proposalId\":\"payment\",\"proposalHandler\":\"Alice\",\"assetType\":\"GBP\",\"amount\":100}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"2h\"}")}
+	res := channelTwo.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal channel two returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	nextTestEvent(t, channelTwo)
+	checkTestBalance(t, channelTwo, "Bob", 0)
+
+	//Alice sees the payment locked, and locks the painting to Bob under the
+	//same hash, expiring first
+	payment := getTestProposal(t, channelTwo, "payment")
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal = "{\"proposalId\":\"delivery\",\"proposalHandler\":\"Bob\",\"assetId\":\"painting\"," +
+		"\"counterpartChannel\":\"channelTwo\",\"counterpartProposalId\":\"payment\"}"
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(payment.Hash), []byte(payment.HashAlgorithm), []byte("{\"timelock\":\"1h\"}")}
+	res = channelOne.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal channel one returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	nextTestEvent(t, channelOne)
+	checkTestAsset(t, channelOne, "painting", "Alice", "delivery")
+
+	//Bob claims the painting, revealing the pre-image
+	channelOne.Creator = newTestIdentity(t, "Bob", nil)
+	res = channelOne.MockInvoke("txid3", [][]byte{[]byte("confirmProposal"), []byte("delivery"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Fatalf("Confirm Proposal channel one returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	checkTestAsset(t, channelOne, "painting", "Bob", "")
+	confirmation, err := events.Decode(nextTestEvent(t, channelOne).Payload)
+	if err != nil {
+		t.Fatalf("Error decoding confirmation event - %s", err.Error())
+	}
+	confirmations := confirmation.OfType(events.Confirmation)
+	if len(confirmations) != 1 {
+		t.Fatalf("Confirm proposal fired events %v, but expected a %s.", confirmation.Events, events.Confirmation)
+	}
+
+	//Alice replays the pre-image to claim the payment
+	channelTwo.Creator = newTestIdentity(t, "Alice", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("payment"), []byte(confirmations[0].PreImage), []byte(confirmations[0].PreImageEncoding)}
+	res = channelTwo.MockInvoke("txid4", args)
+	if res.Status != 200 {
+		t.Fatalf("Confirm Proposal channel two returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	checkTestBalance(t, channelTwo, "Alice", 200)
+	checkTestBalance(t, channelTwo, "Bob", 0)
+	//Alice also held 100 GBP in channel two from the start
+	if getTestProposal(t, channelOne, "delivery").Status != ConfirmStatus {
+		t.Errorf("The delivery in channel one was not confirmed.")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Contract configuration. The configuration for the channel is a JSON
+ * contractConfig document held in state, seeded from the argument to Init
+ * when the chaincode is instantiated or upgraded, e.g.
+ *
+ *   {"Args":["init","{\"hashAlgorithms\":[\"SHA256\"],\"minTimelock\":3600,\"maxTimelock\":604800,
+ *            \"requireAcceptance\":true,\"maxProposalSize\":4096,
+ *            \"accessPolicy\":{\"admins\":[{\"mspId\":\"OpsMSP\"}]}}"]}
+ *
+ * Between upgrades, it can be replaced with setConfig by the admins named in
+ * the access policy. Anything left out of a new configuration takes its
+ * default, the same as with Init. getConfig returns the configuration in
+ * force, so clients can check a proposal against it before submitting.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Replaces the configuration - takes the JSON contractConfig document. Only
+ * the admins in the access policy can change the configuration, and the new
+ * configuration must keep the caller as an admin, so that it can't be locked
+ * by mistake. With no admins configured, it can only be changed by upgrading.
+ */
+func (s *HashTimeLockContract) setConfig(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 1, the configuration
+	if len(args) != 1 {
+		return shim.Error("Invalid arguments to setConfig, expected configuration.")
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	current, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !current.AccessPolicy.canAdminister(caller) {
+		return shim.Error("The transaction creator is not permitted to change the configuration.")
+	}
+	config, err := parseConfig([]byte(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canAdminister(caller) {
+		return shim.Error("The new configuration must keep the transaction creator as an admin.")
+	}
+	err = putConfig(stub, config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Retrieves the configuration in force, with the defaults for anything which
+ * hasn't been configured - takes no arguments.
+ */
+func (s *HashTimeLockContract) getContractConfig(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Invalid arguments to getConfig, expected none.")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return shim.Error("Error when marshaling configuration - " + err.Error())
+	}
+	return shim.Success(configAsBytes)
+}
+
+//parseConfig reads a configuration document, applying the defaults to
+//anything it leaves out, and checks that it is consistent
+func parseConfig(configAsBytes []byte) (contractConfig, error) {
+	config := contractConfig{DefaultTimelock: defaultTimelock, ClockSkewTolerance: defaultClockSkewTolerance, RouteSafetyDelta: defaultRouteSafetyDelta,
+		LinkSafetyMargin: defaultLinkSafetyMargin}
+	err := json.Unmarshal(configAsBytes, &config)
+	if err != nil {
+		return config, fmt.Errorf("Error parsing provided configuration - %s", err.Error())
+	}
+	if config.DefaultTimelock <= 0 {
+		return config, errors.New("The defaultTimelock must be a positive number of seconds.")
+	}
+	if config.ClockSkewTolerance < 0 {
+		return config, errors.New("The clockSkewTolerance cannot be negative.")
+	}
+	if config.MinPreImageLength < 0 {
+		return config, errors.New("The minPreImageLength cannot be negative.")
+	}
+	if config.RouteSafetyDelta < 0 {
+		return config, errors.New("The routeSafetyDelta cannot be negative.")
+	}
+	if config.LinkSafetyMargin < 0 {
+		return config, errors.New("The linkSafetyMargin cannot be negative.")
+	}
+	if config.PrivateData.Collection != "" && len(config.PrivateData.MemberMSPs) == 0 {
+		return config, errors.New("The privateData must list the memberMSPs of the collection.")
+	}
+	for _, algorithm := range config.HashAlgorithms {
+		if _, ok := hashAlgorithms[algorithm]; !ok {
+			return config, fmt.Errorf("The hash algorithm %s in hashAlgorithms is not supported.", algorithm)
+		}
+	}
+	if config.MinTimelock < 0 || config.MaxTimelock < 0 {
+		return config, errors.New("The minTimelock and maxTimelock cannot be negative.")
+	}
+	if config.MaxTimelock != 0 && config.MinTimelock > config.MaxTimelock {
+		return config, errors.New("The minTimelock cannot be greater than the maxTimelock.")
+	}
+	if config.DefaultTimelock < config.MinTimelock || (config.MaxTimelock != 0 && config.DefaultTimelock > config.MaxTimelock) {
+		return config, errors.New("The defaultTimelock must be between the minTimelock and maxTimelock.")
+	}
+	if config.MaxProposalSize < 0 {
+		return config, errors.New("The maxProposalSize cannot be negative.")
+	}
+	return config, nil
+}
+
+//putConfig writes the configuration to state
+func putConfig(stub shim.ChaincodeStubInterface, config contractConfig) error {
+	configAsBytes, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("Error building configuration - %s", err.Error())
+	}
+	err = stub.PutState(configKey, configAsBytes)
+	if err != nil {
+		return fmt.Errorf("Error writing configuration to state - %s", err.Error())
+	}
+	return nil
+}
+
+//allowsHashAlgorithm checks whether proposals can be locked with the hash
+//algorithm, which any in the registry can be when none are configured
+func (config contractConfig) allowsHashAlgorithm(algorithm string) bool {
+	if len(config.HashAlgorithms) == 0 {
+		return true
+	}
+	for _, allowed := range config.HashAlgorithms {
+		if allowed == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+//checkTimelock checks that a new proposal, expiring at expiry, is locked for
+//at least the minTimelock, and no longer than the maxTimelock, from the
+//transaction timestamp
+func (config contractConfig) checkTimelock(stub shim.ChaincodeStubInterface, expiry int64) error {
+	if config.MinTimelock == 0 && config.MaxTimelock == 0 {
+		return nil
+	}
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return err
+	}
+	timelock := expiry - txTime
+	if timelock < config.MinTimelock {
+		return codedError{ErrInvalidTimelock, fmt.Sprintf("The timelock must be at least %d seconds, but is %d seconds.", config.MinTimelock, timelock)}
+	}
+	if config.MaxTimelock != 0 && timelock > config.MaxTimelock {
+		return codedError{ErrInvalidTimelock, fmt.Sprintf("The timelock must be at most %d seconds, but is %d seconds.", config.MaxTimelock, timelock)}
+	}
+	return nil
+}
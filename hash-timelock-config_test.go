@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+//initTestConfig instantiates the contract with the configuration
+func initTestConfig(t *testing.T, stub *testStub, config string) {
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte(config)})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	initTestConfig(t, stub, "{\"accessPolicy\":{\"admins\":[{\"mspId\":\"Ops\"}]}}")
+
+	stub.Creator = newTestIdentity(t, "Mallory", nil)
+	res := stub.MockInvoke("txid1", [][]byte{[]byte("setConfig"), []byte("{\"accessPolicy\":{\"admins\":[{\"mspId\":\"Mallory\"}]}}")})
+	expectedMessage := "The transaction creator is not permitted to change the configuration."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Set Config returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+
+	stub.Creator = newTestIdentity(t, "Ops", nil)
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("setConfig"), []byte("{\"defaultTimelock\":600}")})
+	expectedMessage = "The new configuration must keep the transaction creator as an admin."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Set Config returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("setConfig"), []byte("{\"defaultTimelock\":-1,\"accessPolicy\":{\"admins\":[{\"mspId\":\"Ops\"}]}}")})
+	expectedMessage = "The defaultTimelock must be a positive number of seconds."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Set Config returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("setConfig"), []byte("{\"defaultTimelock\":600,\"accessPolicy\":{\"admins\":[{\"mspId\":\"Ops\"}]}}")})
+	if res.Status != 200 {
+		t.Fatalf("Set Config returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+
+	//Anything left out takes its default
+	res = stub.MockInvoke("txid5", [][]byte{[]byte("getConfig")})
+	if res.Status != 200 {
+		t.Fatalf("Get Config returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	config := contractConfig{}
+	err := json.Unmarshal(res.Payload, &config)
+	if err != nil {
+		t.Fatalf("Error parsing configuration - %s", err.Error())
+	}
+	if config.DefaultTimelock != 600 || config.ClockSkewTolerance != defaultClockSkewTolerance {
+		t.Errorf("Get Config returned %+v, but expected the defaultTimelock set and the default clockSkewTolerance.", config)
+	}
+}
+
+func TestSetConfigWithoutAdmins(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Ops", nil)
+	res := stub.MockInvoke("txid1", [][]byte{[]byte("setConfig"), []byte("{\"accessPolicy\":{\"admins\":[{\"mspId\":\"Ops\"}]}}")})
+	expectedMessage := "The transaction creator is not permitted to change the configuration."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Set Config returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestInitRefusesInconsistentConfig(t *testing.T) {
+	refused := map[string]string{
+		"{\"hashAlgorithms\":[\"MD5\"]}":                 "The hash algorithm MD5 in hashAlgorithms is not supported.",
+		"{\"minTimelock\":7200,\"maxTimelock\":3600}":    "The minTimelock cannot be greater than the maxTimelock.",
+		"{\"defaultTimelock\":600,\"minTimelock\":3600}": "The defaultTimelock must be between the minTimelock and maxTimelock.",
+		"{\"maxProposalSize\":-1}":                       "The maxProposalSize cannot be negative.",
+	}
+	for config, expectedMessage := range refused {
+		stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+		res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte(config)})
+		if res.Status != 500 || res.Message != expectedMessage {
+			t.Errorf("Init with %s returned status %d and error: %s, but expected: %s", config, res.Status, res.Message, expectedMessage)
+		}
+	}
+}
+
+func TestCreateProposalConfiguredPolicies(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	initTestConfig(t, stub, "{\"hashAlgorithms\":[\"SHA256\"],\"minTimelock\":3600,\"maxTimelock\":7200,\"defaultTimelock\":3600,\"maxProposalSize\":64}")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	refused := map[string][][]byte{
+		ErrUnsupportedHashAlgorithm + ": The SHA512 hash algorithm is not allowed on this channel.": {[]byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")},
+		ErrInvalidTimelock + ": The timelock must be at least 3600 seconds, but is 600 seconds.":    {[]byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"10m\"}")},
+		ErrInvalidTimelock + ": The timelock must be at most 7200 seconds, but is 86400 seconds.":   {[]byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"24h\"}")},
+		ErrInvalidProposal + ": The proposal is 79 bytes, but can be at most 64 bytes.": {[]byte("{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"originator\":\"Alice Accounts\"}"),
+			[]byte(testHashes["SHA256"]), []byte("SHA256")},
+	}
+	for expectedMessage, args := range refused {
+		res := stub.MockInvoke("txid1", append([][]byte{[]byte("createProposal")}, args...))
+		if res.Status != 500 || res.Message != expectedMessage {
+			t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+		}
+	}
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"2h\"}")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestCreateProposalConfiguredAcceptance(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	initTestConfig(t, stub, "{\"requireAcceptance\":true}")
+	createTestProposal(t, stub)
+	if !getTestProposal(t, stub, "prop1234").RequireAcceptance {
+		t.Fatal("Create proposal didn't require acceptance, but the configuration requires it.")
+	}
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 500 || res.Message != ErrNotAccepted+": The proposal must be accepted by the handler before it can be confirmed." {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected it to require acceptance.", res.Status, res.Message)
+	}
+}
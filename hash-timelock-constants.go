@@ -1,65 +1,372 @@
 package main
 
+import "encoding/json"
+
 //Prefixes for keys
 
 const proposalPrefix string = "_proposal_"
 
+const assetPrefix string = "_asset_"
+
+//hashIndex is the object type of the composite keys which index proposals by
+//their hash, keyed by the hash then the proposalId
+const hashIndex string = "hash~proposalId"
+
+//balanceIndex is the object type of the composite keys under which token
+//balances are stored, keyed by the account then the asset type
+const balanceIndex string = "balance~account~assetType"
+
+//configKey is the key under which the contract configuration is stored
+const configKey string = "_config_"
+
+//controlsKey is the key under which the emergency controls are stored
+const controlsKey string = "_controls_"
+
+//hashIndexMigrationKey marks that the proposals stored before the hash index
+//was introduced have been indexed, so Init only walks them once
+const hashIndexMigrationKey string = "_migration_hashIndex_"
+
 //Constants for internally set values
 
 //PendingStatus is the default state in which new proposals are placed
 const PendingStatus = "PENDING"
 
+//AcceptedStatus is used once the handler has acknowledged a pending
+//proposal. It can still be confirmed, invalidated or rejected.
+const AcceptedStatus = "ACCEPTED"
+
 //ConfirmStatus is used after the preimage is supplied
 const ConfirmStatus = "CONFIRMED"
 
+//InvalidatedStatus is used once an open proposal has timed out, and is
+//terminal, the proposal can no longer be confirmed
+const InvalidatedStatus = "INVALIDATED"
+
+//RejectedStatus is used once the handler has declined an open proposal, and
+//is terminal, the proposal can no longer be confirmed
+const RejectedStatus = "REJECTED"
+
+//Encodings for hashes and pre-images
+
+//RawEncoding takes the supplied string as the bytes of the pre-image
+const RawEncoding = "raw"
+
+//HexEncoding is hexadecimal, optionally 0x-prefixed, in either case. Hashes
+//are stored in lowercase hexadecimal, without a prefix.
+const HexEncoding = "hex"
+
+//Base64Encoding is standard, padded base64
+const Base64Encoding = "base64"
+
+//Error codes, which prefix the messages of failures validating hashes,
+//pre-images and proposals so that clients can tell them apart
+const (
+	ErrUnsupportedHashAlgorithm = "UNSUPPORTED_HASH_ALGORITHM"
+	ErrInvalidHashEncoding      = "INVALID_HASH_ENCODING"
+	ErrInvalidHashLength        = "INVALID_HASH_LENGTH"
+	ErrInvalidPreImagePolicy    = "INVALID_PRE_IMAGE_POLICY"
+	ErrInvalidPreImageEncoding  = "INVALID_PRE_IMAGE_ENCODING"
+	ErrPreImageTooShort         = "PRE_IMAGE_TOO_SHORT"
+	ErrHashReused               = "HASH_REUSED"
+	ErrInvalidProposal          = "INVALID_PROPOSAL"
+	ErrInsufficientBalance      = "INSUFFICIENT_BALANCE"
+	ErrAssetLocked              = "ASSET_LOCKED"
+	ErrInvalidRoute             = "INVALID_ROUTE"
+	ErrInvalidLink              = "INVALID_LINK"
+	ErrUnsafeTimeout            = "UNSAFE_TIMEOUT"
+	ErrNotAccepted              = "NOT_ACCEPTED"
+	ErrPrivateDataUnavailable   = "PRIVATE_DATA_UNAVAILABLE"
+	ErrInvalidPreImageRecipient = "INVALID_PRE_IMAGE_RECIPIENT"
+	ErrInvalidTimelock          = "INVALID_TIMELOCK"
+	ErrContractPaused           = "CONTRACT_PAUSED"
+	ErrHandlerFrozen            = "HANDLER_FROZEN"
+)
+
+//Page sizes for queries
+
+//defaultPageSize is used when a query doesn't specify a page size
+const defaultPageSize = 20
+
+//maxPageSize caps the number of results returned by a single query
+const maxPageSize = 100
+
+//Defaults used when no configuration has been supplied to Init
+
+//defaultTimelock is the window, in seconds, given to proposals which are
+//created without an explicit timelock or expiry
+const defaultTimelock int64 = 24 * 60 * 60
+
+//defaultClockSkewTolerance is the allowance, in seconds, made for differences
+//between the clocks of the clients which timestamp the transactions
+const defaultClockSkewTolerance int64 = 5 * 60
+
+//defaultRouteSafetyDelta is the time, in seconds, by which each hop of a
+//route must expire after the hop below it, leaving the intermediary time to
+//replay the pre-image
+const defaultRouteSafetyDelta int64 = 60 * 60
+
+//defaultLinkSafetyMargin is the time, in seconds, by which a linked proposal
+//must expire before its counterpart
+const defaultLinkSafetyMargin int64 = 60 * 60
+
 //Object representations
 
-//abstractProposal is a placeholder for a real proposal struct
-type abstractProposal struct {
+//contractConfig is the configuration for the contract on this channel, which
+//can be supplied as a JSON document when instantiating or upgrading.
+//MinPreImageLength is the minimum pre-image length, in bytes, required of
+//every proposal. RefuseHashReuse refuses proposals whose hash has already
+//been used by another proposal. RouteSafetyDelta is the minimum gap, in
+//seconds, between the expiries of consecutive hops of a route, and
+//LinkSafetyMargin the minimum gap between the expiry of a linked proposal and
+//its counterpart. PrivateData keeps new proposals in a private data
+//collection, see hash-timelock-private.go. HashAlgorithms limits which
+//algorithms from the registry proposals can be locked with, any can when it
+//is empty. MinTimelock and MaxTimelock bound, in seconds, how long new
+//proposals can be locked for, MaxTimelock being unbounded when zero.
+//RequireAcceptance makes every new proposal require acceptance by its
+//handler, and MaxProposalSize limits the size of proposal definitions, in
+//bytes, when it isn't zero. See hash-timelock-config.go.
+type contractConfig struct {
+	DefaultTimelock    int64             `json:"defaultTimelock"`
+	ClockSkewTolerance int64             `json:"clockSkewTolerance"`
+	RouteSafetyDelta   int64             `json:"routeSafetyDelta"`
+	LinkSafetyMargin   int64             `json:"linkSafetyMargin"`
+	MinPreImageLength  int               `json:"minPreImageLength"`
+	RefuseHashReuse    bool              `json:"refuseHashReuse"`
+	AccessPolicy       accessPolicy      `json:"accessPolicy"`
+	PrivateData        privateDataConfig `json:"privateData"`
+	HashAlgorithms     []string          `json:"hashAlgorithms,omitempty"`
+	MinTimelock        int64             `json:"minTimelock"`
+	MaxTimelock        int64             `json:"maxTimelock"`
+	RequireAcceptance  bool              `json:"requireAcceptance"`
+	MaxProposalSize    int               `json:"maxProposalSize"`
+}
+
+//privateDataConfig names the Collection which proposals are kept in, leaving
+//them in public state when it is empty. MemberMSPs are the MSPs of the
+//organisations allowed to read the private part of proposals.
+type privateDataConfig struct {
+	Collection string   `json:"collection,omitempty"`
+	MemberMSPs []string `json:"memberMSPs,omitempty"`
+}
+
+//accessPolicy controls which identities can act on proposals. CreatorMSPs
+//limits which MSPs can create proposals, any can when it is empty.
+//HandlerMSPs maps handler names to the MSP allowed to confirm for them,
+//unmapped handler names are taken to be MSP IDs. TimeoutServices are the
+//identities, besides the proposal creator, allowed to invalidate proposals.
+//Minters are the identities allowed to mint tokens and create assets, none
+//can when it is empty. Admins are the identities allowed to change the
+//configuration, none can when it is empty.
+type accessPolicy struct {
+	CreatorMSPs     []string          `json:"creatorMSPs,omitempty"`
+	HandlerMSPs     map[string]string `json:"handlerMSPs,omitempty"`
+	TimeoutServices []identityMatcher `json:"timeoutServices,omitempty"`
+	Minters         []identityMatcher `json:"minters,omitempty"`
+	Admins          []identityMatcher `json:"admins,omitempty"`
+}
+
+//identityMatcher matches identities from an MSP, and if Attribute is set,
+//only those whose certificate holds that attribute with the given Value
+type identityMatcher struct {
+	MSPID     string `json:"mspId"`
+	Attribute string `json:"attribute,omitempty"`
+	Value     string `json:"value,omitempty"`
+}
+
+//clientIdentity is the identity of a transaction creator, as taken from
+//their certificate
+type clientIdentity struct {
+	MSPID      string            `json:"mspId"`
+	ID         string            `json:"id,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+//createOptions holds the optional settings which can be passed as the final
+//argument to createProposal. Timelock is a duration (e.g. "90m") relative to
+//the transaction timestamp, Expiry is an absolute RFC3339 timestamp. At most
+//one of them may be supplied. Retry marks the submission as a retry, which
+//succeeds if an identical proposal has already been stored. HashEncoding is
+//the encoding of the supplied hash, hex when omitted. MinPreImageLength
+//raises the minimum pre-image length, in bytes, above the configured one.
+//RefuseHashReuse refuses the proposal if its hash has already been used, even
+//when the configuration allows reuse. RequireAcceptance means the proposal
+//can't be confirmed until its handler has accepted it. PreImageRecipient is
+//the PEM encoded key to encrypt the pre-image to once it is confirmed.
+type createOptions struct {
+	Timelock          string `json:"timelock"`
+	Expiry            string `json:"expiry"`
+	Retry             bool   `json:"retry"`
+	HashEncoding      string `json:"hashEncoding"`
+	MinPreImageLength int    `json:"minPreImageLength"`
+	RefuseHashReuse   bool   `json:"refuseHashReuse"`
+	RequireAcceptance bool   `json:"requireAcceptance"`
+	PreImageRecipient string `json:"preImageRecipient"`
+}
+
+//proposalDefinition describes what is being proposed. Only the ProposalID
+//and Handler are required, proposals stored before the other fields were
+//introduced have none of them. Originator offers Amount units of AssetType,
+//or the asset AssetID, to Beneficiary. OriginatingChannel is the channel the
+//swap started in, and CounterpartChannel holds the other leg of the swap,
+//with the CounterpartProposalID if it is known. Route lists the hops of a
+//multi-hop payment, see hash-timelock-route.go.
+type proposalDefinition struct {
+	ProposalID            string     `json:"proposalId"`
+	Handler               string     `json:"proposalHandler"`
+	Originator            string     `json:"originator,omitempty"`
+	Beneficiary           string     `json:"beneficiary,omitempty"`
+	AssetType             string     `json:"assetType,omitempty"`
+	Amount                int64      `json:"amount,omitempty"`
+	AssetID               string     `json:"assetId,omitempty"`
+	OriginatingChannel    string     `json:"originatingChannel,omitempty"`
+	CounterpartChannel    string     `json:"counterpartChannel,omitempty"`
+	CounterpartProposalID string     `json:"counterpartProposalId,omitempty"`
+	Route                 []routeHop `json:"route,omitempty"`
+}
+
+//counterpartLink is the evidence, supplied by the creator, of the counterpart
+//of a linked proposal. Expiry is the expiry of the counterpart proposal, in
+//unix seconds, and TxID the transaction which created it in Channel.
+type counterpartLink struct {
+	Channel    string `json:"channel"`
 	ProposalID string `json:"proposalId"`
-	Handler    string `json:"proposalHandler"`
+	Expiry     int64  `json:"expiry"`
+	TxID       string `json:"txId"`
+}
+
+//routeHop is a single hop of a route, where the proposal in Channel is
+//handled by Handler, and expires at Expiry, in unix seconds
+type routeHop struct {
+	Channel string `json:"channel"`
+	Handler string `json:"proposalHandler"`
+	Expiry  int64  `json:"expiry"`
 }
 
 //proposalEntry represents the object which is stored in the state,
 //this could be handled with composite keys if preferred, which would
-//be better in some scenarios
+//be better in some scenarios. Expiry is the unix time (in seconds) at
+//which the timelock on the proposal expires. MinPreImageLength is the
+//shortest pre-image, in bytes, which will be accepted to confirm it.
+//RequireAcceptance is set when it must be accepted before it is confirmed,
+//with the Acceptance recording when the handler accepted it. Escrow
+//records the tokens or asset locked by the proposal, if it has either. Once
+//it is confirmed, the PreImage is kept as reported in the event, so that
+//relayers which missed the event can still replay it, encrypted to the
+//PreImageRecipient if the proposal has one. Link is the evidence of the
+//counterpart proposal, for linked proposals. Private refers to the private
+//part of private proposals, which is all public state holds besides the
+//status and hash lock.
 type proposalEntry struct {
-	Proposal      abstractProposal `json:"proposal"`
-	Status        string           `json:"status"`
-	Hash          string           `json:"hash"`
-	HashAlgorithm string           `json:"hashAlgorithm"`
+	Proposal          proposalDefinition `json:"proposal"`
+	Status            string             `json:"status"`
+	Hash              string             `json:"hash"`
+	HashAlgorithm     string             `json:"hashAlgorithm"`
+	Expiry            int64              `json:"expiry"`
+	MinPreImageLength int                `json:"minPreImageLength,omitempty"`
+	RequireAcceptance bool               `json:"requireAcceptance,omitempty"`
+	PreImageRecipient string             `json:"preImageRecipient,omitempty"`
+	Creator           *clientIdentity    `json:"creator,omitempty"`
+	Escrow            *escrowRecord      `json:"escrow,omitempty"`
+	PreImage          string             `json:"preImage,omitempty"`
+	PreImageEncoding  string             `json:"preImageEncoding,omitempty"`
+	Link              *counterpartLink   `json:"link,omitempty"`
+	Acceptance        *transitionRecord  `json:"acceptance,omitempty"`
+	Invalidation      *transitionRecord  `json:"invalidation,omitempty"`
+	Rejection         *transitionRecord  `json:"rejection,omitempty"`
+	Private           *privateReference  `json:"private,omitempty"`
 }
 
-//Valid hashing algorithms
-var validHashingAlgorithms = []string{"SHA256", "SHA384", "SHA512"}
+//privateReference records the Collection holding the private part of a
+//proposal, and the Commitment to its definition. The salt is only known once
+//the private part has been read.
+type privateReference struct {
+	Collection string `json:"collection"`
+	Commitment string `json:"commitment"`
+	salt       []byte
+}
 
-//Events
+//privateProposal is the private part of a proposal, as stored in its
+//collection, the full Entry with the Salt of the commitment
+type privateProposal struct {
+	Salt  []byte        `json:"salt"`
+	Entry proposalEntry `json:"entry"`
+}
 
-//ProposalCreatedHandlerEvent is fired when an initial proposal is added, it is
-//intended to be prefixed by the intended handler organisation
-const ProposalCreatedHandlerEvent = "_PROPOSAL_CREATED"
+//escrowRecord holds the Amount of AssetType, or the asset AssetID, locked by
+//a proposal, taken from the account From, and paid to the account To once it
+//is confirmed
+type escrowRecord struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	AssetType string `json:"assetType,omitempty"`
+	Amount    int64  `json:"amount,omitempty"`
+	AssetID   string `json:"assetId,omitempty"`
+}
 
-//ProposalCreateTimeoutEvent is fired when an initial proposal is added, it is
-//intended to be handled by a client which makes an invalidate call after some
-//configured client side timeout window.
-const ProposalCreateTimeoutEvent = "PROPOSAL_CREATED"
+//tokenBalance is the balance of an account for an asset type, as returned by
+//balanceOf
+type tokenBalance struct {
+	Account   string `json:"account"`
+	AssetType string `json:"assetType"`
+	Balance   int64  `json:"balance"`
+}
 
-//ProposalCreatedEventObject provides a structure to simplify the creation of a
-//serialised object with the event details. At present, it has nothing in it,
-//but obviously could be augmented with relevant additonal details
-type ProposalCreatedEventObject struct {
-	ProposalID string `json:"proposalId"`
+//transitionRecord captures which transaction moved a proposal between states,
+//when it was timestamped, the MSP of the identity which submitted it, and
+//the reason given for the transition, if any
+type transitionRecord struct {
+	TxID      string `json:"txId"`
+	Timestamp int64  `json:"timestamp"`
+	MSPID     string `json:"mspId"`
+	Reason    string `json:"reason,omitempty"`
 }
 
-//ProposalConfirmedHandlerEvent is fired when a proposal is confirmed, this
-//could be prefixed with the proposed handler or similar, but we are not
-//capturing it here.
-const ProposalConfirmedHandlerEvent = "PROPOSAL_CONFIRMED"
+//contractControls are the emergency controls applied by the admins, see
+//hash-timelock-controls.go. Pause is set while the contract is paused, and
+//FrozenHandlers records why each frozen handler was frozen.
+type contractControls struct {
+	Pause          *pauseRecord                `json:"pause,omitempty"`
+	FrozenHandlers map[string]transitionRecord `json:"frozenHandlers,omitempty"`
+}
 
-//ProposalConfirmedEventObject provides a structure to simplify the creation of a
-//serialised object with the event details. At present, it has nothing in it,
-//but obviously could be augmented with relevant additonal details
-type ProposalConfirmedEventObject struct {
-	ProposalID string `json:"proposalId"`
-	PreImage   string `json:"preImage"`
+//pauseRecord records who paused the contract and why, and whether
+//BlockConfirmations blocks confirmations as well as new proposals
+type pauseRecord struct {
+	transitionRecord
+	BlockConfirmations bool `json:"blockConfirmations"`
+}
+
+//proposalHistoryEntry is a single modification of a proposal, as returned by
+//getProposalHistory. Proposal is the stored entry after the modification.
+type proposalHistoryEntry struct {
+	TxID      string          `json:"txId"`
+	Timestamp int64           `json:"timestamp"`
+	IsDelete  bool            `json:"isDelete"`
+	Proposal  json.RawMessage `json:"proposal,omitempty"`
+}
+
+//proposalQuery is the filter supplied to queryProposals. Empty fields match
+//any proposal. Bookmark is taken from the previous page of results.
+type proposalQuery struct {
+	Status        string `json:"status"`
+	Handler       string `json:"proposalHandler"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+	PageSize      int    `json:"pageSize"`
+	Bookmark      string `json:"bookmark"`
+}
+
+//proposalQueryResponse is a page of results from queryProposals. Bookmark is
+//empty when there are no further results.
+type proposalQueryResponse struct {
+	Proposals []proposalEntry `json:"proposals"`
+	Bookmark  string          `json:"bookmark"`
+}
+
+//assetRecord is a non-fungible asset, as stored in state and returned by
+//ownerOf. LockedBy is the proposalId of the pending proposal locking it.
+type assetRecord struct {
+	AssetID  string `json:"assetId"`
+	Owner    string `json:"owner"`
+	LockedBy string `json:"lockedBy,omitempty"`
 }
@@ -0,0 +1,282 @@
+/*
+ * Emergency controls. If a bug, or a compromised handler, is found, the
+ * admins in the access policy can stop new value being locked. While the
+ * contract is paused with pauseContract, createProposal is refused, and if
+ * asked to, so is confirmProposal. Individual handlers can be frozen with
+ * freezeHandler, which refuses new proposals for them, and the acceptance and
+ * confirmation of their existing ones. Invalidating and rejecting proposals is
+ * always allowed, so locked value can still be refunded.
+ *
+ * Each change fires a PAUSE, RESUME, FREEZE or UNFREEZE event, so that
+ * relayers stop creating proposals for the other leg too, and catch up once
+ * the contract is resumed. The controls are kept apart from the
+ * configuration, so that they survive setConfig and upgrades.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Pauses the contract - takes the reason, and optionally "true" to block
+ * confirmations as well as new proposals. Pausing a paused contract replaces
+ * the reason and whether confirmations are blocked.
+ */
+func (s *HashTimeLockContract) pauseContract(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 1, the reason, plus optionally whether to
+	//block confirmations
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Invalid arguments to pauseContract, expected reason and optionally blockConfirmations.")
+	}
+	blockConfirmations := false
+	if len(args) == 2 {
+		var err error
+		blockConfirmations, err = strconv.ParseBool(args[1])
+		if err != nil {
+			return shim.Error("Error parsing blockConfirmations, expected true or false - " + err.Error())
+		}
+	}
+	caller, controls, err := getAdministeredControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	record, err := newTransitionRecord(stub, caller, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	controls.Pause = &pauseRecord{transitionRecord: *record, BlockConfirmations: blockConfirmations}
+	err = putControls(stub, controls)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	pauseEvent := newControlEvent(stub, events.Pause, "", record)
+	pauseEvent.BlockConfirmations = blockConfirmations
+	err = setProposalEvents(stub, pauseEvent)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Resumes a paused contract - takes an optional reason.
+ */
+func (s *HashTimeLockContract) resumeContract(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect at most the reason
+	if len(args) > 1 {
+		return shim.Error("Invalid arguments to resumeContract, expected optionally reason.")
+	}
+	caller, controls, err := getAdministeredControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if controls.Pause == nil {
+		return shim.Error("The contract is not paused.")
+	}
+	record, err := newTransitionRecord(stub, caller, optionalArg(args, 0))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	controls.Pause = nil
+	err = putControls(stub, controls)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setProposalEvents(stub, newControlEvent(stub, events.Resume, "", record))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Freezes the proposals of a handler - takes the handler and the reason.
+ */
+func (s *HashTimeLockContract) freezeHandler(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 2, the handler and the reason
+	if len(args) != 2 {
+		return shim.Error("Invalid arguments to freezeHandler, expected handler and reason.")
+	}
+	if args[0] == "" {
+		return shim.Error("A handler must be provided.")
+	}
+	caller, controls, err := getAdministeredControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, ok := controls.FrozenHandlers[args[0]]; ok {
+		return shim.Error(fmt.Sprintf("The handler %s is already frozen.", args[0]))
+	}
+	record, err := newTransitionRecord(stub, caller, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if controls.FrozenHandlers == nil {
+		controls.FrozenHandlers = map[string]transitionRecord{}
+	}
+	controls.FrozenHandlers[args[0]] = *record
+	err = putControls(stub, controls)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setProposalEvents(stub, newControlEvent(stub, events.Freeze, args[0], record))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Unfreezes the proposals of a handler - takes the handler, and optionally
+ * the reason.
+ */
+func (s *HashTimeLockContract) unfreezeHandler(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect the handler, plus optionally the reason
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Invalid arguments to unfreezeHandler, expected handler and optionally reason.")
+	}
+	caller, controls, err := getAdministeredControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if _, ok := controls.FrozenHandlers[args[0]]; !ok {
+		return shim.Error(fmt.Sprintf("The handler %s is not frozen.", args[0]))
+	}
+	record, err := newTransitionRecord(stub, caller, optionalArg(args, 1))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	delete(controls.FrozenHandlers, args[0])
+	err = putControls(stub, controls)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setProposalEvents(stub, newControlEvent(stub, events.Unfreeze, args[0], record))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Retrieves the emergency controls in force - takes no arguments.
+ */
+func (s *HashTimeLockContract) getContractControls(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	if len(args) != 0 {
+		return shim.Error("Invalid arguments to getControls, expected none.")
+	}
+	controls, err := getControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	controlsAsBytes, err := json.Marshal(controls)
+	if err != nil {
+		return shim.Error("Error when marshaling controls - " + err.Error())
+	}
+	return shim.Success(controlsAsBytes)
+}
+
+//getControls retrieves the emergency controls from state, which are empty
+//until they are first applied
+func getControls(stub shim.ChaincodeStubInterface) (contractControls, error) {
+	controls := contractControls{}
+	controlsAsBytes, err := stub.GetState(controlsKey)
+	if err != nil {
+		return controls, fmt.Errorf("Error retreiving controls from state - %s", err.Error())
+	}
+	if controlsAsBytes == nil {
+		return controls, nil
+	}
+	err = json.Unmarshal(controlsAsBytes, &controls)
+	if err != nil {
+		return controls, fmt.Errorf("Error parsing controls stored in state - %s", err.Error())
+	}
+	return controls, nil
+}
+
+//putControls writes the emergency controls to state
+func putControls(stub shim.ChaincodeStubInterface, controls contractControls) error {
+	controlsAsBytes, err := json.Marshal(controls)
+	if err != nil {
+		return fmt.Errorf("Error building controls - %s", err.Error())
+	}
+	err = stub.PutState(controlsKey, controlsAsBytes)
+	if err != nil {
+		return fmt.Errorf("Error writing controls to state - %s", err.Error())
+	}
+	return nil
+}
+
+//getAdministeredControls retrieves the emergency controls for a change, which
+//only the admins in the access policy can make, along with the caller
+func getAdministeredControls(stub shim.ChaincodeStubInterface) (clientIdentity, contractControls, error) {
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return caller, contractControls{}, err
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return caller, contractControls{}, err
+	}
+	if !config.AccessPolicy.canAdminister(caller) {
+		return caller, contractControls{}, errors.New("The transaction creator is not permitted to pause the contract or freeze handlers.")
+	}
+	controls, err := getControls(stub)
+	return caller, controls, err
+}
+
+//newControlEvent builds the sub-event for a change to the emergency
+//controls, naming the handler for freezes
+func newControlEvent(stub shim.ChaincodeStubInterface, eventType string, handler string, record *transitionRecord) events.Event {
+	return events.Event{
+		Type:      eventType,
+		Handler:   handler,
+		Reason:    record.Reason,
+		Channel:   stub.GetChannelID(),
+		Timestamp: record.Timestamp,
+	}
+}
+
+//optionalArg gives the argument at the index, or an empty string if there
+//aren't enough arguments
+func optionalArg(args []string, index int) string {
+	if len(args) <= index {
+		return ""
+	}
+	return args[index]
+}
+
+//checkCreate refuses new proposals while the contract is paused, or their
+//handler is frozen
+func (controls contractControls) checkCreate(handler string) error {
+	if controls.Pause != nil {
+		return codedError{ErrContractPaused, "The contract is paused, so proposals can't be created."}
+	}
+	return controls.checkHandler(handler)
+}
+
+//checkConfirm refuses confirmations while the contract is paused with
+//confirmations blocked, or the handler of the proposal is frozen
+func (controls contractControls) checkConfirm(handler string) error {
+	if controls.Pause != nil && controls.Pause.BlockConfirmations {
+		return codedError{ErrContractPaused, "The contract is paused, so proposals can't be confirmed."}
+	}
+	return controls.checkHandler(handler)
+}
+
+//checkHandler refuses to act on the proposals of a frozen handler
+func (controls contractControls) checkHandler(handler string) error {
+	if _, ok := controls.FrozenHandlers[handler]; ok {
+		return codedError{ErrHandlerFrozen, fmt.Sprintf("The proposals of handler %s are frozen.", handler)}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+)
+
+//newTestControlledStub sets up a channel with Ops as its admin
+func newTestControlledStub(t *testing.T) *testStub {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	initTestConfig(t, stub, "{\"accessPolicy\":{\"admins\":[{\"mspId\":\"Ops\"}]}}")
+	return stub
+}
+
+//invokeTestControl invokes an emergency control as Ops, which must succeed
+//and fire an event
+func invokeTestControl(t *testing.T, stub *testStub, txID string, args ...string) string {
+	stub.Creator = newTestIdentity(t, "Ops", nil)
+	byteArgs := [][]byte{}
+	for _, arg := range args {
+		byteArgs = append(byteArgs, []byte(arg))
+	}
+	res := stub.MockInvoke(txID, byteArgs)
+	if res.Status != 200 {
+		t.Fatalf("%s returned non-OK status, got: %d, want: %d - %s", args[0], res.Status, 200, res.Message)
+	}
+	return string(nextTestEvent(t, stub).Payload)
+}
+
+func TestPauseContract(t *testing.T) {
+	stub := newTestControlledStub(t)
+	createTestProposal(t, stub)
+	nextTestEvent(t, stub)
+
+	stub.Creator = newTestIdentity(t, "Mallory", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("pauseContract"), []byte("Mischief")})
+	expectedMessage := "The transaction creator is not permitted to pause the contract or freeze handlers."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Pause Contract returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+
+	payload := invokeTestControl(t, stub, "txid3", "pauseContract", "Investigating")
+	expectedEvent := "{\"events\":[{\"type\":\"PAUSE\",\"proposalId\":\"\",\"proposalHandler\":\"\",\"reason\":\"Investigating\",\"timestamp\":1500000000}]}"
+	if payload != expectedEvent {
+		t.Errorf("Pause contract fired event with payload %s, but expected %s.", payload, expectedEvent)
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Bob\"}"
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")})
+	expectedMessage = ErrContractPaused + ": The contract is paused, so proposals can't be created."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	//Existing proposals can still be confirmed, unless that is blocked too
+	invokeTestControl(t, stub, "txid5", "pauseContract", "Investigating", "true")
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res = stub.MockInvoke("txid6", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	expectedMessage = ErrContractPaused + ": The contract is paused, so proposals can't be confirmed."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	//Refunds are always allowed
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res = stub.MockInvoke("txid7", [][]byte{[]byte("invalidateProposal"), []byte("prop1234")})
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	nextTestEvent(t, stub)
+
+	invokeTestControl(t, stub, "txid8", "resumeContract", "Fixed")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res = stub.MockInvoke("txid9", [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")})
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Ops", nil)
+	res = stub.MockInvoke("txid10", [][]byte{[]byte("resumeContract")})
+	if res.Status != 500 || res.Message != "The contract is not paused." {
+		t.Errorf("Resume Contract returned status %d and error: %s, but expected it to refuse.", res.Status, res.Message)
+	}
+}
+
+func TestFreezeHandler(t *testing.T) {
+	stub := newTestControlledStub(t)
+	createTestProposal(t, stub)
+	nextTestEvent(t, stub)
+
+	payload := invokeTestControl(t, stub, "txid2", "freezeHandler", "Bob", "Compromised")
+	expectedEvent := "{\"events\":[{\"type\":\"FREEZE\",\"proposalId\":\"\",\"proposalHandler\":\"Bob\",\"reason\":\"Compromised\",\"timestamp\":1500000000}]}"
+	if payload != expectedEvent {
+		t.Errorf("Freeze handler fired event with payload %s, but expected %s.", payload, expectedEvent)
+	}
+	expectedMessage := ErrHandlerFrozen + ": The proposals of handler Bob are frozen."
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	refused := [][][]byte{
+		{[]byte("acceptProposal"), []byte("prop1234")},
+		{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")},
+	}
+	for _, args := range refused {
+		res := stub.MockInvoke("txid3", args)
+		if res.Status != 500 || res.Message != expectedMessage {
+			t.Errorf("%s returned status %d and error: %s, but expected: %s", args[0], res.Status, res.Message, expectedMessage)
+		}
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Bob\"}"
+	res := stub.MockInvoke("txid4", [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")})
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	//Other handlers are unaffected
+	testProposal = "{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Charlie\"}"
+	res = stub.MockInvoke("txid5", [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")})
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	nextTestEvent(t, stub)
+
+	invokeTestControl(t, stub, "txid6", "unfreezeHandler", "Bob")
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res = stub.MockInvoke("txid7", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+ * Encrypted pre-images. By default, the pre-image is reported in clear in the
+ * CONFIRMATION event, so any listener on the channel can race the middle-man
+ * to claim the other leg of the swap. A proposal can instead name the public
+ * key of the identity which should receive the pre-image, as a PEM encoded
+ * public key or certificate, with the option
+ *
+ *   {"preImageRecipient": "-----BEGIN PUBLIC KEY-----\n..."}
+ *
+ * Once it is confirmed, the pre-image in the event, and kept with the
+ * proposal, is encrypted to that key, see the ecies package, and reported
+ * with the encoding "ecies". The recipient decrypts it with ecies.Reveal,
+ * giving the pre-image in base64, ready to replay with confirmProposal.
+ *
+ * The pre-image is only kept out of the ledger if it is confirmed through the
+ * transient data, see hash-timelock-transient.go, rather than as an argument.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ecies"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//resolvePreImageRecipient checks the recipient key given for a new proposal,
+//normalising it so that the same key is always stored the same way. It is
+//empty if no recipient was given.
+func resolvePreImageRecipient(options createOptions) (string, error) {
+	if options.PreImageRecipient == "" {
+		return "", nil
+	}
+	pub, err := ecies.ParsePublicKey([]byte(options.PreImageRecipient))
+	if err != nil {
+		return "", codedError{ErrInvalidPreImageRecipient, "Error parsing provided preImageRecipient - " + err.Error()}
+	}
+	recipient, err := ecies.MarshalPublicKey(pub)
+	if err != nil {
+		return "", codedError{ErrInvalidPreImageRecipient, err.Error()}
+	}
+	return recipient, nil
+}
+
+//sealPreImage encrypts the pre-image confirming a proposal to its recipient,
+//giving it in base64 with the ecies encoding. The transaction id is used as
+//the nonce, so every endorser produces the same ciphertext.
+func sealPreImage(stub shim.ChaincodeStubInterface, proposal proposalEntry, preImage []byte) (string, string, error) {
+	pub, err := ecies.ParsePublicKey([]byte(proposal.PreImageRecipient))
+	if err != nil {
+		return "", "", codedError{ErrInvalidPreImageRecipient, "Error parsing the stored preImageRecipient - " + err.Error()}
+	}
+	ciphertext, err := ecies.Encrypt(pub, preImage, []byte(stub.GetTxID()))
+	if err != nil {
+		return "", "", codedError{ErrInvalidPreImageRecipient, "Error encrypting the pre-image - " + err.Error()}
+	}
+	return base64.StdEncoding.EncodeToString(ciphertext), ecies.Encoding, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ecies"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/relayer"
+)
+
+//newTestRecipient generates a key for the recipient of a pre-image, giving
+//the options naming it as the recipient
+func newTestRecipient(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key - %s", err.Error())
+	}
+	recipient, err := ecies.MarshalPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatalf("Error marshaling the public key - %s", err.Error())
+	}
+	optionsAsBytes, err := json.Marshal(createOptions{Timelock: "1h", PreImageRecipient: recipient})
+	if err != nil {
+		t.Fatalf("Error marshaling the options - %s", err.Error())
+	}
+	return key, string(optionsAsBytes)
+}
+
+func TestConfirmProposalEncryptsPreImage(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	key, options := newTestRecipient(t)
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte(options)}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	nextTestEvent(t, stub)
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	stub.TransientMap = map[string][]byte{preImageTransientKey: []byte("test_hash")}
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("")})
+	if res.Status != 200 {
+		t.Fatalf("Confirm Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	envelope, err := events.Decode(nextTestEvent(t, stub).Payload)
+	if err != nil {
+		t.Fatalf("Error decoding the confirmation event - %s", err.Error())
+	}
+	confirmation := envelope.Events[0]
+	if confirmation.PreImageEncoding != ecies.Encoding || confirmation.PreImage == "test_hash" {
+		t.Fatalf("Confirm proposal reported the pre-image %s with encoding %s, but expected it encrypted.", confirmation.PreImage, confirmation.PreImageEncoding)
+	}
+	if stored := getTestProposal(t, stub, "prop1234"); stored.PreImage != confirmation.PreImage {
+		t.Errorf("Confirm proposal stored the pre-image %s, but reported %s.", stored.PreImage, confirmation.PreImage)
+	}
+	//Only the recipient can reveal it, in a form which can be replayed
+	preImage, encoding, err := ecies.Reveal(key, confirmation.PreImage, confirmation.PreImageEncoding)
+	if err != nil {
+		t.Fatalf("Error revealing the pre-image - %s", err.Error())
+	}
+	if encoding != Base64Encoding || preImage != base64.StdEncoding.EncodeToString([]byte("test_hash")) {
+		t.Errorf("Revealed the pre-image %s with encoding %s, but expected test_hash in base64.", preImage, encoding)
+	}
+	other, _ := newTestRecipient(t)
+	_, _, err = ecies.Reveal(other, confirmation.PreImage, confirmation.PreImageEncoding)
+	if err == nil {
+		t.Error("Revealing the pre-image with another key succeeded, but expected an error.")
+	}
+}
+
+func TestCreateProposalInvalidRecipient(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"preImageRecipient\":\"Bob\"}")}
+	res := stub.MockInvoke("txid1", args)
+	expectedMessage := ErrInvalidPreImageRecipient + ": Error parsing provided preImageRecipient - No PEM encoded key or certificate was found."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestRelayerDecryptsPreImage(t *testing.T) {
+	channelOne := newTestStub("channelOne", new(HashTimeLockContract))
+	channelOne.ChannelID = "channelOne"
+	channelTwo := newTestStub("channelTwo", new(HashTimeLockContract))
+	channelTwo.ChannelID = "channelTwo"
+	key, _ := newTestRecipient(t)
+	bob := newTestIdentity(t, "Bob", nil)
+	r, err := relayer.New(
+		relayer.Channel{Name: "channelOne", Ledger: &testLedger{stub: channelOne, creator: bob}, Handler: "Bob", ForwardHandler: "Alice"},
+		relayer.Channel{Name: "channelTwo", Ledger: &testLedger{stub: channelTwo, creator: bob}, Handler: "Bob", ForwardHandler: "Charlie", PreImageKey: key},
+		time.Hour,
+	)
+	if err != nil {
+		t.Fatalf("Relayer creation failed - %s", err.Error())
+	}
+
+	//The proposal mirrored to Charlie names Bob's key as the recipient
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"2h\"}")}
+	res := channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal channel one returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	relayNextEvent(t, r, channelOne)
+	relayNextEvent(t, r, channelTwo)
+	if getTestProposal(t, channelTwo, "prop1234").PreImageRecipient == "" {
+		t.Fatal("Relayer mirrored the proposal without a recipient for the pre-image.")
+	}
+
+	//Charlie's confirmation only reveals the pre-image to Bob, who replays it
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
+	channelTwo.TransientMap = map[string][]byte{preImageTransientKey: []byte("test_hash")}
+	res = channelTwo.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("")})
+	if res.Status != 200 {
+		t.Fatalf("Confirm Proposal channel two returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	if encoding := getTestProposal(t, channelTwo, "prop1234").PreImageEncoding; encoding != ecies.Encoding {
+		t.Errorf("Confirm proposal kept the pre-image with encoding %s, but expected %s.", encoding, ecies.Encoding)
+	}
+	relayNextEvent(t, r, channelTwo)
+	if status := getTestProposal(t, channelOne, "prop1234").Status; status != ConfirmStatus {
+		t.Errorf("Relayer left proposal in channel one as %s, but expected %s.", status, ConfirmStatus)
+	}
+}
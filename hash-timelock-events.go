@@ -0,0 +1,45 @@
+/*
+ * Firing the events of the contract. Each transaction fires one events.Name
+ * event, holding the typed sub-events in an events.Envelope. The types are
+ * kept in the events package, so that listeners can decode the payload with
+ * events.Decode.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//setProposalEvents fires the sub-events as the single event for this
+//transaction. It should only be called once per transaction, as any later
+//call replaces the event.
+func setProposalEvents(stub shim.ChaincodeStubInterface, subEvents ...events.Event) error {
+	envelopeAsBytes, err := json.Marshal(events.Envelope{Events: subEvents})
+	if err != nil {
+		return fmt.Errorf("Error building proposal event definition - %s", err.Error())
+	}
+	err = stub.SetEvent(events.Name, envelopeAsBytes)
+	if err != nil {
+		return fmt.Errorf("Error setting proposal event - %s", err.Error())
+	}
+	return nil
+}
+
+//newUnwindEvent builds the sub-event for a proposal reaching a terminal state
+//other than CONFIRMED, from the record of that transition
+func newUnwindEvent(stub shim.ChaincodeStubInterface, eventType string, proposal proposalEntry, record *transitionRecord) events.Event {
+	return events.Event{
+		Type:       eventType,
+		ProposalID: proposal.Proposal.ProposalID,
+		Handler:    eventHandler(proposal),
+		Hash:       proposal.Hash,
+		Reason:     record.Reason,
+		Channel:    stub.GetChannelID(),
+		Timestamp:  record.Timestamp,
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+)
+
+func TestInvalidateProposalFiresEvent(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.ChannelID = "channelOne"
+	createTestProposal(t, stub)
+	//Clear the event channel
+	nextTestEvent(t, stub)
+
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	args := [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	expectedEvent := "{\"events\":[{\"type\":\"INVALIDATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"," +
+		"\"hash\":\"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6\",\"reason\":\"The timelock on this proposal expired.\"," +
+		"\"channel\":\"channelOne\",\"timestamp\":1500003901}]}"
+	proposalInvalidationEvent := nextTestEvent(t, stub)
+	if proposalInvalidationEvent == nil {
+		t.Fatal("No proposal invalidation event fired!")
+	}
+	if proposalInvalidationEvent.EventName != events.Name {
+		t.Errorf("Invalidate proposal fired event with name %s, but expected %s.", proposalInvalidationEvent.EventName, events.Name)
+	}
+	if string(proposalInvalidationEvent.Payload) != expectedEvent {
+		t.Errorf("Invalidate proposal fired event with payload %s, but expected %s.", string(proposalInvalidationEvent.Payload), expectedEvent)
+	}
+}
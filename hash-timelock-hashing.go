@@ -0,0 +1,186 @@
+/*
+ * Registry of the hashing algorithms which proposals can be locked with. The
+ * same registry is used to validate the algorithm when creating a proposal,
+ * and to check the pre-image when confirming it, so supporting a new
+ * algorithm only takes registering its constructor here.
+ *
+ * Beyond the SHA-2 family, the algorithms used by other chains are supported
+ * so that swaps can be made with them, e.g. KECCAK256 for Ethereum HTLCs,
+ * and HASH160 (RIPEMD-160 of SHA-256) for Bitcoin-style locks.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
+)
+
+//hashAlgorithms maps the names of the supported hashing algorithms to their
+//constructors, hashAlgorithmNames holds the names in registration order
+var hashAlgorithms = map[string]func() hash.Hash{}
+var hashAlgorithmNames []string
+
+func init() {
+	registerHashAlgorithm("SHA256", sha256.New)
+	registerHashAlgorithm("SHA384", sha512.New384)
+	registerHashAlgorithm("SHA512", sha512.New)
+	registerHashAlgorithm("SHA3-256", sha3.New256)
+	registerHashAlgorithm("SHA3-512", sha3.New512)
+	registerHashAlgorithm("KECCAK256", sha3.NewLegacyKeccak256)
+	registerHashAlgorithm("BLAKE2B-256", newBlake2b256)
+	registerHashAlgorithm("RIPEMD160", ripemd160.New)
+	registerHashAlgorithm("HASH160", newHash160)
+}
+
+//registerHashAlgorithm adds a hashing algorithm to the registry, replacing
+//any already registered under the name
+func registerHashAlgorithm(name string, constructor func() hash.Hash) {
+	if _, ok := hashAlgorithms[name]; !ok {
+		hashAlgorithmNames = append(hashAlgorithmNames, name)
+	}
+	hashAlgorithms[name] = constructor
+}
+
+//newHasher creates a hasher for the named algorithm, if it is supported
+func newHasher(name string) (hash.Hash, bool) {
+	constructor, ok := hashAlgorithms[name]
+	if !ok {
+		return nil, false
+	}
+	return constructor(), true
+}
+
+//supportedHashAlgorithms lists the names of the supported algorithms
+func supportedHashAlgorithms() []string {
+	return append([]string(nil), hashAlgorithmNames...)
+}
+
+//codedError is a failure reported with one of the error codes, which
+//prefixes the message
+type codedError struct {
+	code    string
+	message string
+}
+
+func (e codedError) Error() string {
+	return e.code + ": " + e.message
+}
+
+//errUnsupportedHashAlgorithm lists the supported algorithms
+func errUnsupportedHashAlgorithm() error {
+	return codedError{ErrUnsupportedHashAlgorithm, "Only these hashing algorithms are supported: " + strings.Join(supportedHashAlgorithms(), ", ")}
+}
+
+//normaliseHash checks that a hash supplied in the encoding is a digest of
+//the algorithm, and returns it as lowercase hexadecimal
+func normaliseHash(hash string, encoding string, algorithm string) (string, error) {
+	hasher, ok := newHasher(algorithm)
+	if !ok {
+		return "", errUnsupportedHashAlgorithm()
+	}
+	hashBytes, err := decodeHash(hash, encoding)
+	if err != nil {
+		return "", codedError{ErrInvalidHashEncoding, "Error decoding provided hash - " + err.Error()}
+	}
+	if len(hashBytes) != hasher.Size() {
+		return "", codedError{ErrInvalidHashLength, fmt.Sprintf("%s hashes are %d bytes, but %d bytes were provided.", algorithm, hasher.Size(), len(hashBytes))}
+	}
+	return hex.EncodeToString(hashBytes), nil
+}
+
+//decodeHash decodes a hash supplied in the encoding, hex when none is given
+func decodeHash(hash string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", HexEncoding:
+		return decodeHex(hash)
+	case Base64Encoding:
+		return decodeBase64(hash)
+	default:
+		return nil, fmt.Errorf("Hashes can only be encoded as %s or %s.", HexEncoding, Base64Encoding)
+	}
+}
+
+//decodePreImage decodes a pre-image supplied in the encoding, raw when none
+//is given
+func decodePreImage(preImage string, encoding string) ([]byte, error) {
+	switch encoding {
+	case "", RawEncoding:
+		return []byte(preImage), nil
+	case HexEncoding:
+		return decodeHex(preImage)
+	case Base64Encoding:
+		return decodeBase64(preImage)
+	default:
+		return nil, fmt.Errorf("Pre-images can only be encoded as %s, %s or %s.", RawEncoding, HexEncoding, Base64Encoding)
+	}
+}
+
+//encodePreImageForEvent gives the pre-image and encoding to report in events.
+//Raw pre-images which aren't valid UTF-8 would be mangled in the JSON
+//payload, so are reported in base64 instead.
+func encodePreImageForEvent(preImage string, encoding string) (string, string) {
+	if encoding == "" {
+		encoding = RawEncoding
+	}
+	if encoding == RawEncoding && !utf8.ValidString(preImage) {
+		return base64.StdEncoding.EncodeToString([]byte(preImage)), Base64Encoding
+	}
+	return preImage, encoding
+}
+
+func decodeHex(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		value = value[2:]
+	}
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding hexadecimal - %s", err.Error())
+	}
+	return decoded, nil
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding base64 - %s", err.Error())
+	}
+	return decoded, nil
+}
+
+//newBlake2b256 creates an unkeyed BLAKE2b hasher with a 256 bit digest
+func newBlake2b256() hash.Hash {
+	//Only fails when given a key which is too long
+	hasher, _ := blake2b.New256(nil)
+	return hasher
+}
+
+//hash160 is RIPEMD-160 applied to the SHA-256 digest of the data, as used
+//in Bitcoin scripts
+type hash160 struct {
+	hash.Hash
+}
+
+func newHash160() hash.Hash {
+	return hash160{sha256.New()}
+}
+
+func (h hash160) Sum(b []byte) []byte {
+	outer := ripemd160.New()
+	outer.Write(h.Hash.Sum(nil))
+	return outer.Sum(b)
+}
+
+func (h hash160) Size() int {
+	return ripemd160.Size
+}
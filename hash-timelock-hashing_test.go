@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashAlgorithms(t *testing.T) {
+	//Digests of "test_hash" for each of the registered algorithms
+	digests := map[string]string{
+		"SHA256":      "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6",
+		"SHA3-256":    "65d75d82c5e1a83a0de2c5efd62b4ce2453ad042a9235a2d8fef9315f0818e86",
+		"SHA3-512":    "867689ecdf2f05b642b7770ede91ad2144cdc13996a8619425fb5498e7cf851b2a37d78d983a8c8e8ef9905e20b8efaa70b43bb63ce7353e755d15b3bca217cf",
+		"KECCAK256":   "74ee1301970ba3b72d3abd85d5f4f288c277025d2fdf2bf7f7a3397a303242d7",
+		"BLAKE2B-256": "2327ac9b7c3003a796ed2afdf062270bd6810106e2b89bd55c6a213b432b281a",
+		"RIPEMD160":   "4d7d89c57437f54a5719fbc8b3e49183336c6b6b",
+		"HASH160":     "1c15f3dfe4a523431979a8fbf0246bb2e89ebd77",
+	}
+	for name, expected := range digests {
+		hasher, ok := newHasher(name)
+		if !ok {
+			t.Errorf("Hashing algorithm %s is not registered.", name)
+			continue
+		}
+		hasher.Write([]byte("test_hash"))
+		digest := hex.EncodeToString(hasher.Sum(nil))
+		if digest != expected {
+			t.Errorf("%s produced digest %s, but expected %s.", name, digest, expected)
+		}
+		if hasher.Size()*2 != len(expected) {
+			t.Errorf("%s reports a size of %d, but produces %d bytes.", name, hasher.Size(), len(expected)/2)
+		}
+	}
+}
+
+func TestUnknownHashAlgorithm(t *testing.T) {
+	if _, ok := newHasher("MD5"); ok {
+		t.Error("MD5 is registered, but expected it not to be.")
+	}
+}
+
+func TestDecodePreImage(t *testing.T) {
+	encodings := map[string]string{
+		RawEncoding:    "test_hash",
+		HexEncoding:    "0x746573745F68617368",
+		Base64Encoding: "dGVzdF9oYXNo",
+	}
+	for encoding, preImage := range encodings {
+		decoded, err := decodePreImage(preImage, encoding)
+		if err != nil {
+			t.Errorf("Error decoding %s pre-image - %s", encoding, err.Error())
+			continue
+		}
+		if string(decoded) != "test_hash" {
+			t.Errorf("Decoding %s pre-image %s gave %q, but expected test_hash.", encoding, preImage, decoded)
+		}
+	}
+	if _, err := decodePreImage("not_hex", HexEncoding); err == nil {
+		t.Error("Decoded an invalid hex pre-image, but expected an error.")
+	}
+}
+
+func TestEncodePreImageForEvent(t *testing.T) {
+	preImage, encoding := encodePreImageForEvent("test_hash", "")
+	if preImage != "test_hash" || encoding != RawEncoding {
+		t.Errorf("Reported pre-image as %s %s, but expected test_hash raw.", preImage, encoding)
+	}
+	preImage, encoding = encodePreImageForEvent(string([]byte{0xff, 0xfe}), RawEncoding)
+	if preImage != "//4=" || encoding != Base64Encoding {
+		t.Errorf("Reported binary pre-image as %s %s, but expected //4= base64.", preImage, encoding)
+	}
+}
+
+func TestNormaliseHash(t *testing.T) {
+	hash, err := normaliseHash("0X6B70A820EB978882FA49B199C853A5676E5E1A4744371BE5AFFD4B3AF1F5DDE6", "", "SHA256")
+	if err != nil || hash != "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6" {
+		t.Errorf("Normalised hash to %s, but expected lowercase hexadecimal.", hash)
+	}
+	failures := []struct {
+		hash      string
+		algorithm string
+		code      string
+	}{
+		{"not_hex", "SHA256", ErrInvalidHashEncoding},
+		{"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6", "HASH160", ErrInvalidHashLength},
+		{"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6", "KECCAK512", ErrUnsupportedHashAlgorithm},
+	}
+	for _, failure := range failures {
+		_, err := normaliseHash(failure.hash, HexEncoding, failure.algorithm)
+		if coded, ok := err.(codedError); !ok || coded.code != failure.code {
+			t.Errorf("Normalising %s as %s gave %v, but expected %s.", failure.hash, failure.algorithm, err, failure.code)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Links between the legs of a swap. The middle-man (Org B) is paid by the
+ * originating leg, and pays out on the receiving leg, so the receiving leg
+ * must expire first. Otherwise the pre-image could be revealed on the
+ * receiving leg after the originating leg has been refunded, leaving Org B
+ * out of pocket.
+ *
+ * A linked proposal records the expiry of its counterpart, with the id of the
+ * transaction which created it, as supplied by the relayer. It is refused
+ * unless it expires at least linkSafetyMargin before the counterpart. The
+ * evidence can't be checked from this channel, so it is only as trustworthy
+ * as the creator who supplies it, but it is kept with the proposal for
+ * auditing.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Creates a proposal linked to its counterpart - takes the same arguments as
+ * createProposal, with the JSON counterpartLink inserted before the optional
+ * options, e.g.
+ *
+ *   {"Args":["createLinkedProposal", proposal, hash, "SHA256",
+ *            "{\"channel\":\"channelOne\",\"proposalId\":\"prop1234\",\"expiry\":1500007200,\"txId\":\"abc\"}"]}
+ */
+func (s *HashTimeLockContract) createLinkedProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 4, the proposal, the hash, the hashing algorithm
+	//and the link, plus optionally the options object
+	if len(args) != 4 && len(args) != 5 {
+		return shim.Error("Invalid arguments to createLinkedProposal, expected proposal, hash, hashingAlg, link and optionally options.")
+	}
+	link, err := parseCounterpartLink([]byte(args[3]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return s.newProposal(stub, append(args[:3:3], args[4:]...), &link)
+}
+
+/*
+ * Links an existing pending proposal to its counterpart - takes the
+ * proposalId and the JSON counterpartLink. Only the creator of the proposal
+ * can link it, and only once.
+ */
+func (s *HashTimeLockContract) linkProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 2, the proposalId and the link
+	if len(args) != 2 {
+		return shim.Error("Invalid arguments to linkProposal, expected proposalId and link.")
+	}
+	link, err := parseCounterpartLink([]byte(args[1]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal, ok, err := getStoredProposal(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		return shim.Error("No such proposal.")
+	}
+	if !isOpen(proposal.Status) {
+		return shim.Error("Only pending proposals can be linked.")
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !isSameIdentity(proposal.Creator, caller) {
+		return shim.Error("Only the proposal creator can link this proposal.")
+	}
+	if proposal.Link != nil {
+		return shim.Error("The proposal is already linked to its counterpart.")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = checkLink(stub, proposal, link, config.LinkSafetyMargin)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal.Link = &link
+	err = putStoredProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//parseCounterpartLink strictly decodes the link supplied for a proposal,
+//which must be complete
+func parseCounterpartLink(linkAsBytes []byte) (counterpartLink, error) {
+	link := counterpartLink{}
+	decoder := json.NewDecoder(bytes.NewReader(linkAsBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&link)
+	if err != nil {
+		return link, codedError{ErrInvalidLink, "Error parsing provided link - " + err.Error()}
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return link, codedError{ErrInvalidLink, "Error parsing provided link - unexpected data after the link"}
+	}
+	if link.Channel == "" || link.ProposalID == "" || link.TxID == "" || link.Expiry <= 0 {
+		return link, codedError{ErrInvalidLink, "The link must have the channel, proposalId, expiry and txId of the counterpart."}
+	}
+	return link, nil
+}
+
+//checkLink checks that a link points at the counterpart of the proposal in
+//another channel, and that the proposal expires at least margin seconds
+//before the counterpart
+func checkLink(stub shim.ChaincodeStubInterface, proposal proposalEntry, link counterpartLink, margin int64) error {
+	if link.Channel == stub.GetChannelID() {
+		return codedError{ErrInvalidLink, "The counterpart must be in another channel."}
+	}
+	definition := proposal.Proposal
+	if (definition.CounterpartChannel != "" && definition.CounterpartChannel != link.Channel) ||
+		(definition.CounterpartProposalID != "" && definition.CounterpartProposalID != link.ProposalID) {
+		return codedError{ErrInvalidLink, "The link must be to the counterpart named in the proposal."}
+	}
+	if proposal.Expiry > link.Expiry-margin {
+		return codedError{ErrUnsafeTimeout, fmt.Sprintf("The proposal expires at %d, but must expire at least %d seconds before its counterpart, which expires at %d.",
+			proposal.Expiry, margin, link.Expiry)}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+func TestCreateLinkedProposal(t *testing.T) {
+	stub := newTestStub("channelTwo", new(HashTimeLockContract))
+	stub.ChannelID = "channelTwo"
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Charlie\",\"counterpartChannel\":\"channelOne\"}"
+	refused := map[string]string{
+		"{\"channel\":\"channelOne\",\"proposalId\":\"prop1234\",\"expiry\":1500005400,\"txId\":\"tx1\"}": ErrUnsafeTimeout +
+			": The proposal expires at 1500003600, but must expire at least 3600 seconds before its counterpart, which expires at 1500005400.",
+		"{\"channel\":\"channelTwo\",\"proposalId\":\"prop1234\",\"expiry\":1500007200,\"txId\":\"tx1\"}": ErrInvalidLink + ": The counterpart must be in another channel.",
+		"{\"channel\":\"channelThree\",\"proposalId\":\"prop1234\",\"expiry\":1500007200,\"txId\":\"tx1\"}": ErrInvalidLink +
+			": The link must be to the counterpart named in the proposal.",
+		"{\"channel\":\"channelOne\",\"proposalId\":\"prop1234\",\"expiry\":1500007200}": ErrInvalidLink +
+			": The link must have the channel, proposalId, expiry and txId of the counterpart.",
+	}
+	for link, expectedMessage := range refused {
+		args := [][]byte{[]byte("createLinkedProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte(link), []byte("{\"timelock\":\"1h\"}")}
+		res := stub.MockInvoke("txid1", args)
+		if res.Status != 500 || res.Message != expectedMessage {
+			t.Errorf("Create Linked Proposal with %s returned status %d and error: %s, but expected: %s", link, res.Status, res.Message, expectedMessage)
+		}
+	}
+
+	link := "{\"channel\":\"channelOne\",\"proposalId\":\"prop1234\",\"expiry\":1500007200,\"txId\":\"tx1\"}"
+	args := [][]byte{[]byte("createLinkedProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte(link), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Create Linked Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposal := getTestProposal(t, stub, "prop1234")
+	expectedLink := counterpartLink{Channel: "channelOne", ProposalID: "prop1234", Expiry: testTime + 2*60*60, TxID: "tx1"}
+	if proposal.Link == nil || *proposal.Link != expectedLink {
+		t.Errorf("Create linked proposal recorded link %+v, but expected %+v.", proposal.Link, expectedLink)
+	}
+}
+
+func TestLinkProposal(t *testing.T) {
+	stub := newTestStub("channelTwo", new(HashTimeLockContract))
+	stub.ChannelID = "channelTwo"
+	createTestProposal(t, stub)
+	link := "{\"channel\":\"channelOne\",\"proposalId\":\"prop5678\",\"expiry\":1500007200,\"txId\":\"tx1\"}"
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("linkProposal"), []byte("prop1234"), []byte(link)})
+	if res.Status != 500 || res.Message != "Only the proposal creator can link this proposal." {
+		t.Errorf("Link Proposal returned status %d and error: %s, but expected Bob to be refused.", res.Status, res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	unsafeLink := "{\"channel\":\"channelOne\",\"proposalId\":\"prop5678\",\"expiry\":1500003600,\"txId\":\"tx1\"}"
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("linkProposal"), []byte("prop1234"), []byte(unsafeLink)})
+	if res.Status != 500 || !strings.HasPrefix(res.Message, ErrUnsafeTimeout+": ") {
+		t.Errorf("Link Proposal returned status %d and error: %s, but expected %s.", res.Status, res.Message, ErrUnsafeTimeout)
+	}
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("linkProposal"), []byte("prop1234"), []byte(link)})
+	if res.Status != 200 {
+		t.Errorf("Link Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	if getTestProposal(t, stub, "prop1234").Link == nil {
+		t.Errorf("Link proposal didn't record the link.")
+	}
+	res = stub.MockInvoke("txid5", [][]byte{[]byte("linkProposal"), []byte("prop1234"), []byte(link)})
+	if res.Status != 500 || res.Message != "The proposal is already linked to its counterpart." {
+		t.Errorf("Link Proposal returned status %d and error: %s, but expected the second link to be refused.", res.Status, res.Message)
+	}
+}
+
+func TestRelayerLinksMirroredProposals(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"2h\"}")}
+	res := channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	relayNextEvent(t, r, channelOne)
+	mirrored := getTestProposal(t, channelTwo, "prop1234")
+	expectedLink := counterpartLink{Channel: "channelOne", ProposalID: "prop1234", Expiry: testTime + 2*60*60, TxID: "txid1"}
+	if mirrored.Link == nil || *mirrored.Link != expectedLink {
+		t.Errorf("Relayer linked the mirrored proposal with %+v, but expected %+v.", mirrored.Link, expectedLink)
+	}
+}
+
+func TestRelayerRefusedUnsafeTimeout(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+	//Channel two requires more than the relayer's hour of margin
+	res := channelTwo.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"linkSafetyMargin\":7200}")})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"2h\"}")}
+	res = channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	ccEvent := nextTestEvent(t, channelOne)
+	err := r.HandleEvent("channelOne", ledger.Event{TxID: ccEvent.TxId, Name: ccEvent.EventName, Payload: ccEvent.Payload})
+	if err == nil || !strings.Contains(err.Error(), ErrUnsafeTimeout+": ") {
+		t.Errorf("Relayer mirrored with error %v, but expected %s.", err, ErrUnsafeTimeout)
+	}
+	proposal, _ := channelTwo.GetState(proposalPrefix + "prop1234")
+	if proposal != nil {
+		t.Errorf("Relayer mirrored %s, but expected it to be refused.", string(proposal))
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+//testTime is the transaction timestamp (in unix seconds) used by default in
+//tests which need predictable timestamps
+const testTime int64 = 1500000000
+
+//testHashes are the digests of the pre-image "test_hash", for tests which
+//need a valid hash
+var testHashes = map[string]string{
+	"SHA256": "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6",
+	"SHA384": "708af8efbb882bb662a5a5f19d3164133621266903cec7ee0ce9eca950a7b7f8d09defedb4474da4257274741f2a07a8",
+	"SHA512": "5a32f0967623012cdd4c29257f808f3f209184e992c39dc6d931f89831e7b1eb9379f9e3a20da09eb06d0ca53bd9c0845dda91baed17a713c0cac8a24259c0b9",
+}
+
+//testStub wraps the shim MockStub so that tests can control values which the
+//MockStub does not allow to be set, such as the transaction timestamp and
+//creator, and records the history of each key
+type testStub struct {
+	*shim.MockStub
+	cc      shim.Chaincode
+	args    [][]byte
+	history map[string][]*queryresult.KeyModification
+	//TxTime is the transaction timestamp, in unix seconds, presented to the
+	//chaincode for the next invocations
+	TxTime int64
+	//Creator is the serialised identity presented to the chaincode as the
+	//creator of the next invocations
+	Creator []byte
+}
+
+func newTestStub(name string, cc shim.Chaincode) *testStub {
+	return &testStub{
+		MockStub: shim.NewMockStub(name, cc),
+		cc:       cc,
+		history:  make(map[string][]*queryresult.KeyModification),
+		TxTime:   testTime,
+	}
+}
+
+//MockInit initialises the chaincode, also starts and ends a transaction
+func (stub *testStub) MockInit(uuid string, args [][]byte) peer.Response {
+	stub.args = args
+	stub.MockTransactionStart(uuid)
+	res := stub.cc.Init(stub)
+	stub.MockTransactionEnd(uuid)
+	return res
+}
+
+//MockInvoke invokes the chaincode, also starts and ends a transaction. As on
+//a peer, nothing written by a failed invocation is kept.
+func (stub *testStub) MockInvoke(uuid string, args [][]byte) peer.Response {
+	stub.args = args
+	state, pvtState, history := stub.snapshot()
+	stub.MockTransactionStart(uuid)
+	res := stub.cc.Invoke(stub)
+	stub.MockTransactionEnd(uuid)
+	if res.Status >= shim.ERRORTHRESHOLD {
+		stub.restore(state, pvtState, history)
+	}
+	return res
+}
+
+//snapshot copies the public state, private state and history of the stub
+func (stub *testStub) snapshot() (map[string][]byte, map[string]map[string][]byte, map[string][]*queryresult.KeyModification) {
+	state := make(map[string][]byte, len(stub.State))
+	for key, value := range stub.State {
+		state[key] = value
+	}
+	pvtState := make(map[string]map[string][]byte, len(stub.PvtState))
+	for collection, values := range stub.PvtState {
+		pvtState[collection] = make(map[string][]byte, len(values))
+		for key, value := range values {
+			pvtState[collection][key] = value
+		}
+	}
+	history := make(map[string][]*queryresult.KeyModification, len(stub.history))
+	for key, modifications := range stub.history {
+		history[key] = modifications
+	}
+	return state, pvtState, history
+}
+
+//restore puts back a snapshot of the stub, rebuilding the sorted keys used
+//for range queries
+func (stub *testStub) restore(state map[string][]byte, pvtState map[string]map[string][]byte, history map[string][]*queryresult.KeyModification) {
+	stub.State, stub.PvtState, stub.history = state, pvtState, history
+	keys := make([]string, 0, len(state))
+	for key := range state {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	stub.Keys.Init()
+	for _, key := range keys {
+		stub.Keys.PushBack(key)
+	}
+}
+
+//nextTestEvent returns the next event fired through the stub, failing the
+//test rather than blocking if none was fired
+func nextTestEvent(t *testing.T, stub *testStub) *peer.ChaincodeEvent {
+	select {
+	case ccEvent := <-stub.ChaincodeEventsChannel:
+		return ccEvent
+	case <-time.After(time.Second):
+		t.Fatalf("No event was fired in %s.", stub.Name)
+		return nil
+	}
+}
+
+func (stub *testStub) GetArgs() [][]byte {
+	return stub.args
+}
+
+func (stub *testStub) GetStringArgs() []string {
+	strargs := make([]string, 0, len(stub.args))
+	for _, barg := range stub.args {
+		strargs = append(strargs, string(barg))
+	}
+	return strargs
+}
+
+func (stub *testStub) GetFunctionAndParameters() (function string, params []string) {
+	allargs := stub.GetStringArgs()
+	function = ""
+	params = []string{}
+	if len(allargs) >= 1 {
+		function = allargs[0]
+		params = allargs[1:]
+	}
+	return
+}
+
+func (stub *testStub) GetTxTimestamp() (*timestamp.Timestamp, error) {
+	return &timestamp.Timestamp{Seconds: stub.TxTime}, nil
+}
+
+func (stub *testStub) GetCreator() ([]byte, error) {
+	return stub.Creator, nil
+}
+
+func (stub *testStub) PutState(key string, value []byte) error {
+	err := stub.MockStub.PutState(key, value)
+	if err != nil {
+		return err
+	}
+	stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+		TxId: stub.TxID, Value: value, Timestamp: &timestamp.Timestamp{Seconds: stub.TxTime},
+	})
+	return nil
+}
+
+func (stub *testStub) DelState(key string) error {
+	err := stub.MockStub.DelState(key)
+	if err != nil {
+		return err
+	}
+	stub.history[key] = append(stub.history[key], &queryresult.KeyModification{
+		TxId: stub.TxID, IsDelete: true, Timestamp: &timestamp.Timestamp{Seconds: stub.TxTime},
+	})
+	return nil
+}
+
+func (stub *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	return &testHistoryIterator{modifications: stub.history[key]}, nil
+}
+
+//testHistoryIterator iterates over the modifications recorded by a testStub,
+//oldest first
+type testHistoryIterator struct {
+	modifications []*queryresult.KeyModification
+	position      int
+}
+
+func (iter *testHistoryIterator) HasNext() bool {
+	return iter.position < len(iter.modifications)
+}
+
+func (iter *testHistoryIterator) Next() (*queryresult.KeyModification, error) {
+	modification := iter.modifications[iter.position]
+	iter.position++
+	return modification, nil
+}
+
+func (iter *testHistoryIterator) Close() error {
+	return nil
+}
+
+//newTestIdentity builds a serialised identity for the MSP, with a self-signed
+//certificate carrying the attributes in the same form as the Fabric CA
+func newTestIdentity(t *testing.T, mspID string, attrs map[string]string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating test key - %s", err.Error())
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user@" + mspID, Organization: []string{mspID}},
+		NotBefore:    time.Unix(testTime, 0).Add(-time.Hour),
+		NotAfter:     time.Unix(testTime, 0).Add(24 * 365 * time.Hour),
+	}
+	if attrs != nil {
+		attrsAsBytes, err := json.Marshal(map[string]map[string]string{"attrs": attrs})
+		if err != nil {
+			t.Fatalf("Error building test certificate attributes - %s", err.Error())
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}, Value: attrsAsBytes}}
+	}
+	certAsBytes, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error building test certificate - %s", err.Error())
+	}
+	identity := &msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certAsBytes}),
+	}
+	identityAsBytes, err := proto.Marshal(identity)
+	if err != nil {
+		t.Fatalf("Error serialising test identity - %s", err.Error())
+	}
+	return identityAsBytes
+}
+
+//testIdentityID is the id which the chaincode derives from the certificate
+//of an identity built by newTestIdentity
+func testIdentityID(mspID string) string {
+	dn := "CN=user@" + mspID + ",O=" + mspID
+	return base64.StdEncoding.EncodeToString([]byte("x509::" + dn + "::" + dn))
+}
+
+//testLedger lets clients reach the contract through a testStub, invoking it
+//as a fixed identity
+type testLedger struct {
+	stub    *testStub
+	creator []byte
+	txCount int
+}
+
+func (l *testLedger) Events(ctx context.Context) (<-chan ledger.Event, error) {
+	events := make(chan ledger.Event)
+	go func() {
+		defer close(events)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ccEvent := <-l.stub.ChaincodeEventsChannel:
+				select {
+				case events <- ledger.Event{TxID: ccEvent.TxId, Name: ccEvent.EventName, Payload: ccEvent.Payload}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return events, nil
+}
+
+func (l *testLedger) Invoke(function string, args ...string) ([]byte, error) {
+	l.txCount++
+	l.stub.Creator = l.creator
+	byteArgs := [][]byte{[]byte(function)}
+	for _, arg := range args {
+		byteArgs = append(byteArgs, []byte(arg))
+	}
+	res := l.stub.MockInvoke(fmt.Sprintf("relayer-tx%d", l.txCount), byteArgs)
+	if res.Status != 200 {
+		return nil, errors.New(res.Message)
+	}
+	return res.Payload, nil
+}
+
+func (l *testLedger) Query(function string, args ...string) ([]byte, error) {
+	return l.Invoke(function, args...)
+}
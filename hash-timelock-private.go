@@ -0,0 +1,212 @@
+/*
+ * Private proposals. When the configuration names a private data collection,
+ * new proposals are split in two. Public state keeps what every member of the
+ * channel needs to enforce the hash lock - the status, hash, hashing
+ * algorithm and expiry - with a salted commitment to the proposal definition.
+ * The full entry, with the handler, the business terms and the creator, is
+ * kept in the collection, e.g. with the configuration
+ *
+ *   {"privateData": {"collection": "swapTerms", "memberMSPs": ["OrgAMSP", "OrgBMSP"]}}
+ *
+ * The salt, of at least minSaltLength bytes, is passed in the transient data
+ * as "salt", so it is never recorded in the transaction, and the definition
+ * can't be recovered from the commitment by guessing. It is kept with the
+ * private entry, so the commitment is checked whenever the entry is read.
+ *
+ * getProposal, queryProposals and getProposalsByHash return the full entry to
+ * callers from the memberMSPs, when the peer holds the collection, and the
+ * public part to everyone else. Events for private proposals leave out the
+ * handler, so listeners resolve it with getProposal. Token balances, and the
+ * records of assets, stay in public state, so private proposals can't lock
+ * an amount or an asset, as the escrow would be visible there.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//saltTransientKey is the key of the transient data holding the salt for a
+//private proposal
+const saltTransientKey = "salt"
+
+//minSaltLength is the shortest salt, in bytes, accepted for a private
+//proposal
+const minSaltLength = 16
+
+//getStoredProposal retrieves a proposal from state, along with its private
+//part if it has one, reporting whether it exists. The private part must be
+//available, so this is for transactions which act on the proposal.
+func getStoredProposal(stub shim.ChaincodeStubInterface, proposalID string) (proposalEntry, bool, error) {
+	proposal := proposalEntry{}
+	proposalAsBytes, err := stub.GetState(proposalPrefix + proposalID)
+	if err != nil {
+		return proposal, false, fmt.Errorf("Error while retreiving the stored proposal from state - %s", err.Error())
+	}
+	if proposalAsBytes == nil {
+		return proposal, false, nil
+	}
+	err = json.Unmarshal(proposalAsBytes, &proposal)
+	if err != nil {
+		return proposal, false, fmt.Errorf("Error while parsing the proposal stored in state - %s", err.Error())
+	}
+	if proposal.Private == nil {
+		return proposal, true, nil
+	}
+	proposal, err = resolvePrivateProposal(stub, proposal)
+	if err != nil {
+		return proposal, false, err
+	}
+	return proposal, true, nil
+}
+
+//putStoredProposal writes a proposal to state. Private proposals are split,
+//with the full entry written to their collection and only the public part
+//written to state.
+func putStoredProposal(stub shim.ChaincodeStubInterface, proposal proposalEntry) error {
+	key := proposalPrefix + proposal.Proposal.ProposalID
+	public := proposal
+	if proposal.Private != nil {
+		public = proposalEntry{
+			Proposal:      proposalDefinition{ProposalID: proposal.Proposal.ProposalID},
+			Status:        proposal.Status,
+			Hash:          proposal.Hash,
+			HashAlgorithm: proposal.HashAlgorithm,
+			Expiry:        proposal.Expiry,
+			Private:       proposal.Private,
+		}
+		entry := proposal
+		entry.Private = nil
+		privateAsBytes, err := json.Marshal(privateProposal{Salt: proposal.Private.salt, Entry: entry})
+		if err != nil {
+			return fmt.Errorf("Error when marshaling the private proposal - %s", err.Error())
+		}
+		err = stub.PutPrivateData(proposal.Private.Collection, key, privateAsBytes)
+		if err != nil {
+			return fmt.Errorf("Error writing the private proposal to collection %s - %s", proposal.Private.Collection, err.Error())
+		}
+	}
+	proposalAsBytes, err := json.Marshal(public)
+	if err != nil {
+		return fmt.Errorf("Error when marshaling proposal - %s", err.Error())
+	}
+	err = stub.PutState(key, proposalAsBytes)
+	if err != nil {
+		return fmt.Errorf("Error writing proposal to state - %s", err.Error())
+	}
+	return nil
+}
+
+//newPrivateReference commits to the definition of a new proposal, when the
+//configuration keeps proposals private, using the salt from the transient
+//data. It returns nil for public proposals.
+func newPrivateReference(stub shim.ChaincodeStubInterface, config contractConfig, definition proposalDefinition) (*privateReference, error) {
+	if config.PrivateData.Collection == "" {
+		return nil, nil
+	}
+	if definition.Amount != 0 || definition.AssetID != "" {
+		return nil, codedError{ErrInvalidProposal, "Private proposals can't lock an amount or an asset, as balances and assets are kept in public state."}
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, fmt.Errorf("Error retreiving the transient data - %s", err.Error())
+	}
+	salt := transient[saltTransientKey]
+	if len(salt) < minSaltLength {
+		return nil, codedError{ErrInvalidProposal, fmt.Sprintf("Private proposals need a salt of at least %d bytes, passed in the transient data as %s.",
+			minSaltLength, saltTransientKey)}
+	}
+	commitment, err := commitTo(salt, definition)
+	if err != nil {
+		return nil, err
+	}
+	return &privateReference{Collection: config.PrivateData.Collection, Commitment: commitment, salt: salt}, nil
+}
+
+//resolvePrivateProposal reads the private part of a proposal from its
+//collection, checking it against the commitment held in public state
+func resolvePrivateProposal(stub shim.ChaincodeStubInterface, public proposalEntry) (proposalEntry, error) {
+	privateAsBytes, err := stub.GetPrivateData(public.Private.Collection, proposalPrefix+public.Proposal.ProposalID)
+	if err != nil {
+		return public, fmt.Errorf("Error retreiving the private proposal from collection %s - %s", public.Private.Collection, err.Error())
+	}
+	if privateAsBytes == nil {
+		return public, codedError{ErrPrivateDataUnavailable, "The private details of this proposal aren't available on this peer."}
+	}
+	private := privateProposal{}
+	err = json.Unmarshal(privateAsBytes, &private)
+	if err != nil {
+		return public, fmt.Errorf("Error while parsing the private proposal - %s", err.Error())
+	}
+	commitment, err := commitTo(private.Salt, private.Entry.Proposal)
+	if err != nil {
+		return public, err
+	}
+	if commitment != public.Private.Commitment {
+		return public, fmt.Errorf("The private details of proposal %s don't match its commitment.", public.Proposal.ProposalID)
+	}
+	proposal := private.Entry
+	proposal.Private = &privateReference{Collection: public.Private.Collection, Commitment: public.Private.Commitment, salt: private.Salt}
+	return proposal, nil
+}
+
+//readableProposal gives the view of a stored proposal which the caller is
+//allowed to read. The private part is only resolved for members of the
+//collection, and if it isn't available, the public part is returned.
+func readableProposal(stub shim.ChaincodeStubInterface, public proposalEntry) proposalEntry {
+	if public.Private == nil {
+		return public
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return public
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil || !config.PrivateData.isMember(caller) {
+		return public
+	}
+	proposal, err := resolvePrivateProposal(stub, public)
+	if err != nil {
+		return public
+	}
+	return proposal
+}
+
+//isMember checks whether the identity belongs to one of the member MSPs of
+//the collection
+func (privateData privateDataConfig) isMember(identity clientIdentity) bool {
+	for _, mspID := range privateData.MemberMSPs {
+		if identity.MSPID == mspID {
+			return true
+		}
+	}
+	return false
+}
+
+//commitTo builds the commitment to a proposal definition, the hexadecimal
+//SHA256 digest of the salt followed by the definition
+func commitTo(salt []byte, definition proposalDefinition) (string, error) {
+	definitionAsBytes, err := json.Marshal(definition)
+	if err != nil {
+		return "", fmt.Errorf("Error building the commitment to the proposal - %s", err.Error())
+	}
+	hasher := sha256.New()
+	hasher.Write(salt)
+	hasher.Write(definitionAsBytes)
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+//eventHandler is the handler named in the events for a proposal, which is
+//left out for private proposals
+func eventHandler(proposal proposalEntry) string {
+	if proposal.Private != nil {
+		return ""
+	}
+	return proposal.Proposal.Handler
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/relayer"
+)
+
+//testSalt is the salt passed in the transient data for private proposals
+const testSalt = "0123456789abcdef"
+
+//newTestPrivateStub sets up a channel which keeps proposals in the swapTerms
+//collection, shared by Alice and Bob, and creates a private proposal from
+//Alice to Bob
+func newTestPrivateStub(t *testing.T) *testStub {
+	stub := newTestStub("channelOne", new(HashTimeLockContract))
+	stub.ChannelID = "channelOne"
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"privateData\":{\"collection\":\"swapTerms\",\"memberMSPs\":[\"Alice\",\"Bob\"]}}")})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	stub.TransientMap = map[string][]byte{saltTransientKey: []byte(testSalt)}
+	createTestProposal(t, stub)
+	stub.TransientMap = nil
+	return stub
+}
+
+//getTestProposalAs retrieves a proposal with getProposal, as a caller from
+//the MSP
+func getTestProposalAs(t *testing.T, stub *testStub, mspID string, proposalID string) proposalEntry {
+	stub.Creator = newTestIdentity(t, mspID, nil)
+	res := stub.MockInvoke("query", [][]byte{[]byte("getProposal"), []byte(proposalID)})
+	if res.Status != 200 {
+		t.Fatalf("Get Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	proposal := proposalEntry{}
+	err := json.Unmarshal(res.Payload, &proposal)
+	if err != nil {
+		t.Fatalf("Error parsing proposal %s - %s", proposalID, err.Error())
+	}
+	return proposal
+}
+
+func TestCreatePrivateProposal(t *testing.T) {
+	stub := newTestPrivateStub(t)
+	commitment, err := commitTo([]byte(testSalt), proposalDefinition{ProposalID: "prop1234", Handler: "Bob"})
+	if err != nil {
+		t.Fatalf("Error building commitment - %s", err.Error())
+	}
+	//Only the hash lock and commitment are public
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"\"},\"status\":\"PENDING\"," +
+		"\"hash\":\"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500003600," +
+		"\"private\":{\"collection\":\"swapTerms\",\"commitment\":\"" + commitment + "\"}}"
+	proposal, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if string(proposal) != expectedRes {
+		t.Errorf("Create private proposal stored %s, but expected: %s.", string(proposal), expectedRes)
+	}
+	if stub.PvtState["swapTerms"][proposalPrefix+"prop1234"] == nil {
+		t.Errorf("Create private proposal didn't write to the collection.")
+	}
+	expectedEvent := "{\"events\":[{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"\",\"expiry\":1500003600}," +
+		"{\"type\":\"TIMEOUT_REGISTRATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"\",\"expiry\":1500003600}]}"
+	proposalCreatedEvent := nextTestEvent(t, stub)
+	if string(proposalCreatedEvent.Payload) != expectedEvent {
+		t.Errorf("Create private proposal fired event with payload %s, but expected %s.", string(proposalCreatedEvent.Payload), expectedEvent)
+	}
+
+	//Members see the whole proposal, others only the public part
+	if handler := getTestProposalAs(t, stub, "Bob", "prop1234").Proposal.Handler; handler != "Bob" {
+		t.Errorf("Get Proposal gave a member the handler %s, but expected Bob.", handler)
+	}
+	outsider := getTestProposalAs(t, stub, "Charlie", "prop1234")
+	if outsider.Proposal.Handler != "" || outsider.Creator != nil {
+		t.Errorf("Get Proposal gave a non-member %+v, but expected only the public part.", outsider)
+	}
+	query := [][]byte{[]byte("queryProposals"), []byte("{\"proposalHandler\":\"Bob\"}")}
+	res := stub.MockInvoke("query", query)
+	if res.Status != 200 || strings.Contains(string(res.Payload), "prop1234") {
+		t.Errorf("Query Proposals gave a non-member %s, but expected no proposals.", string(res.Payload))
+	}
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res = stub.MockInvoke("query", query)
+	if res.Status != 200 || !strings.Contains(string(res.Payload), "prop1234") {
+		t.Errorf("Query Proposals gave a member %s, but expected the proposal.", string(res.Payload))
+	}
+}
+
+func TestCreatePrivateProposalWithoutSalt(t *testing.T) {
+	stub := newTestPrivateStub(t)
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Bob\"}"
+	stub.TransientMap = map[string][]byte{saltTransientKey: []byte("short")}
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid2", args)
+	expectedMessage := ErrInvalidProposal + ": Private proposals need a salt of at least 16 bytes, passed in the transient data as salt."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestCreatePrivateProposalWithEscrow(t *testing.T) {
+	stub := newTestPrivateStub(t)
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	stub.TransientMap = map[string][]byte{saltTransientKey: []byte(testSalt)}
+	expectedMessage := ErrInvalidProposal + ": Private proposals can't lock an amount or an asset, as balances and assets are kept in public state."
+	for _, testProposal := range []string{
+		"{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Bob\",\"assetType\":\"GBP\",\"amount\":60}",
+		"{\"proposalId\":\"prop5678\",\"proposalHandler\":\"Bob\",\"assetId\":\"painting\"}",
+	} {
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+		res := stub.MockInvoke("txid2", args)
+		if res.Status != 500 || res.Message != expectedMessage {
+			t.Errorf("Create Proposal of %s returned status %d and error: %s, but expected: %s", testProposal, res.Status, res.Message, expectedMessage)
+		}
+	}
+}
+
+func TestConfirmPrivateProposal(t *testing.T) {
+	stub := newTestPrivateStub(t)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	if getTestProposal(t, stub, "prop1234").Status != ConfirmStatus {
+		t.Errorf("Confirm proposal didn't update the public status.")
+	}
+	if proposal := getTestProposalAs(t, stub, "Bob", "prop1234"); proposal.Status != ConfirmStatus || proposal.PreImage != "test_hash" {
+		t.Errorf("Confirm proposal left the private proposal as %+v.", proposal)
+	}
+}
+
+func TestPrivateProposalUnavailable(t *testing.T) {
+	stub := newTestPrivateStub(t)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	//A peer outside the collection doesn't hold the private part
+	delete(stub.PvtState, "swapTerms")
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	expectedMessage := ErrPrivateDataUnavailable + ": The private details of this proposal aren't available on this peer."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	if handler := getTestProposalAs(t, stub, "Bob", "prop1234").Proposal.Handler; handler != "" {
+		t.Errorf("Get Proposal gave the handler %s, which the peer doesn't hold.", handler)
+	}
+}
+
+func TestPrivateProposalTampered(t *testing.T) {
+	stub := newTestPrivateStub(t)
+	key := proposalPrefix + "prop1234"
+	stub.PvtState["swapTerms"][key] = []byte(strings.Replace(string(stub.PvtState["swapTerms"][key]), "\"Bob\"", "\"Mallory\"", 1))
+	stub.Creator = newTestIdentity(t, "Mallory", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	expectedMessage := "The private details of proposal prop1234 don't match its commitment."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestInitPrivateDataWithoutMembers(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"privateData\":{\"collection\":\"swapTerms\"}}")})
+	expectedMessage := "The privateData must list the memberMSPs of the collection."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Init returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestRelayerMirrorsPrivateProposals(t *testing.T) {
+	channelOne := newTestPrivateStub(t)
+	channelTwo := newTestStub("channelTwo", new(HashTimeLockContract))
+	channelTwo.ChannelID = "channelTwo"
+	//The proposal only has an hour to run, so mirror it with the same expiry
+	res := channelTwo.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"linkSafetyMargin\":0}")})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	bob := newTestIdentity(t, "Bob", nil)
+	r, err := relayer.New(
+		relayer.Channel{Name: "channelOne", Ledger: &testLedger{stub: channelOne, creator: bob}, Handler: "Bob", ForwardHandler: "Alice"},
+		relayer.Channel{Name: "channelTwo", Ledger: &testLedger{stub: channelTwo, creator: bob}, Handler: "Bob", ForwardHandler: "Charlie"},
+		0,
+	)
+	if err != nil {
+		t.Fatalf("Relayer creation failed - %s", err.Error())
+	}
+	//The event doesn't name Bob, so the relayer reads the proposal to find
+	//the handler
+	relayNextEvent(t, r, channelOne)
+	mirrored := getTestProposal(t, channelTwo, "prop1234")
+	if mirrored.Proposal.Handler != "Charlie" {
+		t.Errorf("Relayer mirrored proposal %+v, but expected a proposal for Charlie.", mirrored)
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * The proposal model, describing what is being exchanged by a proposal. New
+ * proposals are decoded strictly, so that misspelt or unsupported fields are
+ * refused rather than silently dropped, and then checked against the rules in
+ * validate.
+ *
+ * Proposals stored before the model was introduced only carry a proposalId
+ * and proposalHandler. They are read from state with the normal decoding, so
+ * remain readable with the other fields left empty.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+//parseProposalDefinition decodes a proposal submitted to createProposal,
+//rejecting unknown fields and anything following the proposal object
+func parseProposalDefinition(definitionAsBytes []byte) (proposalDefinition, error) {
+	definition := proposalDefinition{}
+	decoder := json.NewDecoder(bytes.NewReader(definitionAsBytes))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&definition)
+	if err != nil {
+		return proposalDefinition{}, err
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return proposalDefinition{}, errors.New("unexpected data after the proposal")
+	}
+	return definition, nil
+}
+
+//validate checks that a proposal is complete and consistent. The proposalId
+//and proposalHandler are always required, the other fields are optional but
+//must agree with each other when given.
+func (definition proposalDefinition) validate() error {
+	if definition.ProposalID == "" {
+		return errors.New("No proposalId provided as part of proposal.")
+	}
+	if definition.Handler == "" {
+		//There should probably be a lot more validation of this handler - but we
+		//will just accept what is passed for this sample
+		return errors.New("No proposalHandler provided as part of proposal.")
+	}
+	if definition.Amount < 0 {
+		return codedError{ErrInvalidProposal, "The amount cannot be negative."}
+	}
+	if definition.Amount > 0 && definition.AssetType == "" {
+		return codedError{ErrInvalidProposal, "An assetType must be provided with an amount."}
+	}
+	if definition.AssetType != "" && definition.Amount == 0 {
+		return codedError{ErrInvalidProposal, "An amount must be provided with an assetType."}
+	}
+	if definition.AssetID != "" && (definition.Amount != 0 || definition.AssetType != "") {
+		return codedError{ErrInvalidProposal, "A proposal can lock an assetId or an amount, but not both."}
+	}
+	if definition.Originator != "" && definition.Originator == definition.Beneficiary {
+		return codedError{ErrInvalidProposal, "The originator and beneficiary must be different."}
+	}
+	if definition.CounterpartProposalID != "" && definition.CounterpartChannel == "" {
+		return codedError{ErrInvalidProposal, "A counterpartChannel must be provided with a counterpartProposalId."}
+	}
+	if len(definition.Route) > 0 {
+		return validateRoute(definition.Route)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCreateProposalWithTypedFields(t *testing.T) {
+	//Alice needs the tokens to lock
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"originator\":\"Alice\",\"beneficiary\":\"Charlie\"," +
+		"\"assetType\":\"GBP\",\"amount\":100,\"originatingChannel\":\"channelOne\",\"counterpartChannel\":\"channelTwo\"," +
+		"\"counterpartProposalId\":\"prop5678\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposal, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if !strings.HasPrefix(string(proposal), "{\"proposal\":"+testProposal+",") {
+		t.Errorf("Create proposal created %s, but expected it to keep every field of %s.", string(proposal), testProposal)
+	}
+}
+
+func TestCreateProposalUnknownField(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	proposals := []string{
+		"{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"amuont\":100}",
+		"{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}{}",
+	}
+	for _, testProposal := range proposals {
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+		res := stub.MockInvoke("txid1", args)
+		if res.Status != 500 {
+			t.Errorf("Create Proposal returned OK status for %s, got: %d, want: %d.", testProposal, res.Status, 500)
+		}
+		if !strings.HasPrefix(res.Message, "Error parsing provided proposal definition - ") {
+			t.Errorf("Create proposal failed for %s with: %s, but expected a parsing error.", testProposal, res.Message)
+		}
+	}
+}
+
+func TestProposalDefinitionValidation(t *testing.T) {
+	invalid := map[string]proposalDefinition{
+		"negative amount":      {ProposalID: "prop1", Handler: "Bob", AssetType: "GBP", Amount: -1},
+		"amount without asset": {ProposalID: "prop1", Handler: "Bob", Amount: 10},
+		"asset without amount": {ProposalID: "prop1", Handler: "Bob", AssetType: "GBP"},
+		"paying yourself":      {ProposalID: "prop1", Handler: "Bob", Originator: "Alice", Beneficiary: "Alice"},
+		"counterpart proposal": {ProposalID: "prop1", Handler: "Bob", CounterpartProposalID: "prop2"},
+		"asset and amount":     {ProposalID: "prop1", Handler: "Bob", AssetID: "painting", AssetType: "GBP", Amount: 10},
+	}
+	for name, definition := range invalid {
+		err := definition.validate()
+		if err == nil {
+			t.Errorf("Validating a proposal with %s succeeded, but expected an error.", name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), ErrInvalidProposal+": ") {
+			t.Errorf("Validating a proposal with %s failed with: %s, but expected code %s.", name, err.Error(), ErrInvalidProposal)
+		}
+	}
+	valid := proposalDefinition{ProposalID: "prop1", Handler: "Bob", Originator: "Alice", Beneficiary: "Charlie",
+		AssetType: "GBP", Amount: 10, CounterpartChannel: "channelTwo", CounterpartProposalID: "prop2"}
+	if err := valid.validate(); err != nil {
+		t.Errorf("Validating a complete proposal failed - %s", err.Error())
+	}
+}
+
+func TestConfirmLegacyProposal(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	//A proposal stored before the proposal model was introduced
+	legacyProposal := "{\"proposal\":{\"proposalId\":\"legacy\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"," +
+		"\"hash\":\"" + testHashes["SHA256"] + "\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500086400}"
+	stub.MockTransactionStart("txid0")
+	stub.PutState(proposalPrefix+"legacy", []byte(legacyProposal))
+	stub.MockTransactionEnd("txid0")
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("confirmProposal"), []byte("legacy"), []byte("test_hash")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposal, err := stub.GetState(proposalPrefix + "legacy")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if !strings.HasPrefix(string(proposal), "{\"proposal\":{\"proposalId\":\"legacy\",\"proposalHandler\":\"Bob\"},\"status\":\"CONFIRMED\"") {
+		t.Errorf("Confirm proposal stored %s, but expected the legacy proposal confirmed.", string(proposal))
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Read-only functions for retrieving proposals, allowing clients to reconcile
+ * their view of the proposals against the state, rather than relying solely
+ * upon the events.
+ */
+
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Returns the stored proposal entry for a proposalId. Only the public part of
+ * private proposals is returned, unless the caller is a member of their
+ * collection.
+ */
+func (s *HashTimeLockContract) getProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 1, the proposalId
+	if len(args) != 1 {
+		return shim.Error("Invalid arguments to getProposal, expected proposalId.")
+	}
+	proposalAsBytes, err := stub.GetState(proposalPrefix + args[0])
+	if err != nil {
+		return shim.Error("Error while retreiving the stored proposal from state - " + err.Error())
+	}
+	if proposalAsBytes == nil {
+		return shim.Error("No such proposal.")
+	}
+	proposal := proposalEntry{}
+	err = json.Unmarshal(proposalAsBytes, &proposal)
+	if err != nil {
+		return shim.Error("Error while parsing the proposal stored in state - " + err.Error())
+	}
+	if proposal.Private == nil {
+		return shim.Success(proposalAsBytes)
+	}
+	proposalAsBytes, err = json.Marshal(readableProposal(stub, proposal))
+	if err != nil {
+		return shim.Error("Error building proposal - " + err.Error())
+	}
+	return shim.Success(proposalAsBytes)
+}
+
+/*
+ * Returns a page of the stored proposals, filtered by an optional JSON
+ * proposalQuery. This walks the proposals in key order, and the bookmark
+ * returned is the proposalId of the next match, from which the following page
+ * starts.
+ * Private proposals only match on their handler for members of their
+ * collection.
+ * The paginated state APIs aren't used, as the filtering happens here rather
+ * than in the state database, so their pages could come back short or empty.
+ */
+func (s *HashTimeLockContract) queryProposals(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect at most 1, the query
+	if len(args) > 1 {
+		return shim.Error("Invalid arguments to queryProposals, expected optionally query.")
+	}
+	query := proposalQuery{}
+	if len(args) == 1 {
+		err := json.Unmarshal([]byte(args[0]), &query)
+		if err != nil {
+			return shim.Error("Error parsing provided query - " + err.Error())
+		}
+	}
+	if query.PageSize < 0 || query.PageSize > maxPageSize {
+		return shim.Error(fmt.Sprintf("The pageSize must be between 1 and %d.", maxPageSize))
+	}
+	if query.PageSize == 0 {
+		query.PageSize = defaultPageSize
+	}
+	iterator, err := stub.GetStateByRange(proposalPrefix+query.Bookmark, proposalPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return shim.Error("Error while querying proposals from state - " + err.Error())
+	}
+	defer iterator.Close()
+	response := proposalQueryResponse{Proposals: []proposalEntry{}}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return shim.Error("Error while querying proposals from state - " + err.Error())
+		}
+		proposal := proposalEntry{}
+		err = json.Unmarshal(kv.Value, &proposal)
+		if err != nil {
+			return shim.Error("Error while parsing the proposal stored in state - " + err.Error())
+		}
+		proposal = readableProposal(stub, proposal)
+		if !query.matches(proposal) {
+			continue
+		}
+		if len(response.Proposals) == query.PageSize {
+			response.Bookmark = proposal.Proposal.ProposalID
+			break
+		}
+		response.Proposals = append(response.Proposals, proposal)
+	}
+	responseAsBytes, err := json.Marshal(response)
+	if err != nil {
+		return shim.Error("Error building query response - " + err.Error())
+	}
+	return shim.Success(responseAsBytes)
+}
+
+//matches checks whether a proposal passes the filters set on the query
+func (query proposalQuery) matches(proposal proposalEntry) bool {
+	if query.Status != "" && query.Status != proposal.Status {
+		return false
+	}
+	if query.Handler != "" && query.Handler != proposal.Proposal.Handler {
+		return false
+	}
+	if query.HashAlgorithm != "" && query.HashAlgorithm != proposal.HashAlgorithm {
+		return false
+	}
+	return true
+}
+
+/*
+ * Returns every proposal locked with a hash, using the hash index, so that
+ * the dependent proposals can be found once a pre-image is revealed, however
+ * their proposalIds were chosen. The hash is expected in hexadecimal, unless
+ * an encoding of base64 is given.
+ */
+func (s *HashTimeLockContract) getProposalsByHash(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect the hash, plus optionally its encoding
+	if len(args) != 1 && len(args) != 2 {
+		return shim.Error("Invalid arguments to getProposalsByHash, expected hash and optionally encoding.")
+	}
+	encoding := HexEncoding
+	if len(args) == 2 && args[1] != "" {
+		encoding = args[1]
+	}
+	hashBytes, err := decodeHash(args[0], encoding)
+	if err != nil {
+		return shim.Error(codedError{ErrInvalidHashEncoding, "Error decoding provided hash - " + err.Error()}.Error())
+	}
+	iterator, err := stub.GetStateByPartialCompositeKey(hashIndex, []string{hex.EncodeToString(hashBytes)})
+	if err != nil {
+		return shim.Error("Error while querying the hash index - " + err.Error())
+	}
+	defer iterator.Close()
+	proposals := []proposalEntry{}
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return shim.Error("Error while querying the hash index - " + err.Error())
+		}
+		_, attributes, err := stub.SplitCompositeKey(kv.Key)
+		if err != nil || len(attributes) != 2 {
+			return shim.Error("Error while parsing the hash index.")
+		}
+		proposalAsBytes, err := stub.GetState(proposalPrefix + attributes[1])
+		if err != nil {
+			return shim.Error("Error while retreiving the stored proposal from state - " + err.Error())
+		}
+		if proposalAsBytes == nil {
+			continue
+		}
+		proposal := proposalEntry{}
+		err = json.Unmarshal(proposalAsBytes, &proposal)
+		if err != nil {
+			return shim.Error("Error while parsing the proposal stored in state - " + err.Error())
+		}
+		proposals = append(proposals, readableProposal(stub, proposal))
+	}
+	proposalsAsBytes, err := json.Marshal(proposals)
+	if err != nil {
+		return shim.Error("Error building query response - " + err.Error())
+	}
+	return shim.Success(proposalsAsBytes)
+}
+
+/*
+ * Returns every modification made to a proposal, so that auditors can
+ * reconstruct its lifecycle. Requires the history database to be enabled on
+ * the peer.
+ */
+func (s *HashTimeLockContract) getProposalHistory(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 1, the proposalId
+	if len(args) != 1 {
+		return shim.Error("Invalid arguments to getProposalHistory, expected proposalId.")
+	}
+	iterator, err := stub.GetHistoryForKey(proposalPrefix + args[0])
+	if err != nil {
+		return shim.Error("Error while retreiving the proposal history - " + err.Error())
+	}
+	defer iterator.Close()
+	history := []proposalHistoryEntry{}
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return shim.Error("Error while retreiving the proposal history - " + err.Error())
+		}
+		entry := proposalHistoryEntry{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			entry.Timestamp = modification.Timestamp.Seconds
+		}
+		if !modification.IsDelete {
+			entry.Proposal = modification.Value
+		}
+		history = append(history, entry)
+	}
+	if len(history) == 0 {
+		return shim.Error("No such proposal.")
+	}
+	historyAsBytes, err := json.Marshal(history)
+	if err != nil {
+		return shim.Error("Error building proposal history - " + err.Error())
+	}
+	return shim.Success(historyAsBytes)
+}
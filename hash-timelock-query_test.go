@@ -0,0 +1,338 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestGetProposalSuccess(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	args = [][]byte{[]byte("getProposal"), []byte("prop1234")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Get Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"" + testHashes["SHA512"] + "\",\"hashAlgorithm\":\"SHA512\",\"expiry\":1500086400," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"}}"
+	if string(res.Payload) != expectedRes {
+		t.Errorf("Get proposal returned %s, but expected: %s.", string(res.Payload), expectedRes)
+	}
+}
+
+func TestGetProposalNoSuchProposal(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	args := [][]byte{[]byte("getProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 {
+		t.Errorf("Get Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "No such proposal."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestQueryProposalsWithFilters(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	//Proposals for a couple of handlers, with a couple of algorithms
+	proposals := []struct{ id, handler, alg string }{
+		{"prop1", "Bob", "SHA512"},
+		{"prop2", "Charlie", "SHA512"},
+		{"prop3", "Bob", "SHA256"},
+		{"prop4", "Bob", "SHA512"},
+	}
+	for _, p := range proposals {
+		testProposal := "{\"proposalId\": \"" + p.id + "\", \"proposalHandler\": \"" + p.handler + "\"}"
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes[p.alg]), []byte(p.alg)}
+		res := stub.MockInvoke("txid-"+p.id, args)
+		if res.Status != 200 {
+			t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+		}
+	}
+	args := [][]byte{[]byte("queryProposals"), []byte("{\"status\":\"PENDING\",\"proposalHandler\":\"Bob\",\"hashAlgorithm\":\"SHA512\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Query Proposals returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	response := proposalQueryResponse{}
+	err := json.Unmarshal(res.Payload, &response)
+	if err != nil {
+		t.Error("Error parsing query response - " + err.Error())
+	}
+	if len(response.Proposals) != 2 || response.Proposals[0].Proposal.ProposalID != "prop1" || response.Proposals[1].Proposal.ProposalID != "prop4" {
+		t.Errorf("Query proposals returned %s, but expected prop1 and prop4.", string(res.Payload))
+	}
+	if response.Bookmark != "" {
+		t.Errorf("Query proposals returned bookmark %s on the final page.", response.Bookmark)
+	}
+	//Nothing has been confirmed
+	args = [][]byte{[]byte("queryProposals"), []byte("{\"status\":\"CONFIRMED\"}")}
+	res = stub.MockInvoke("txid2", args)
+	expectedRes := "{\"proposals\":[],\"bookmark\":\"\"}"
+	if string(res.Payload) != expectedRes {
+		t.Errorf("Query proposals returned %s, but expected: %s.", string(res.Payload), expectedRes)
+	}
+}
+
+func TestQueryProposalsPagination(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	for _, id := range []string{"prop1", "prop2", "prop3"} {
+		testProposal := "{\"proposalId\": \"" + id + "\", \"proposalHandler\": \"Bob\"}"
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
+		res := stub.MockInvoke("txid-"+id, args)
+		if res.Status != 200 {
+			t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+		}
+	}
+	//Walk the pages until there is no bookmark
+	var found []string
+	bookmark := ""
+	for page := 0; page < 3; page++ {
+		args := [][]byte{[]byte("queryProposals"), []byte("{\"pageSize\":2,\"bookmark\":\"" + bookmark + "\"}")}
+		res := stub.MockInvoke("txid1", args)
+		if res.Status != 200 {
+			t.Errorf("Query Proposals returned non-OK status, got: %d, want: %d.", res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+		}
+		response := proposalQueryResponse{}
+		err := json.Unmarshal(res.Payload, &response)
+		if err != nil {
+			t.Error("Error parsing query response - " + err.Error())
+		}
+		for _, p := range response.Proposals {
+			found = append(found, p.Proposal.ProposalID)
+		}
+		bookmark = response.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+	if len(found) != 3 || found[0] != "prop1" || found[1] != "prop2" || found[2] != "prop3" {
+		t.Errorf("Paging through proposals returned %v, but expected [prop1 prop2 prop3].", found)
+	}
+}
+
+func TestQueryProposalsInvalidPageSize(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	args := [][]byte{[]byte("queryProposals"), []byte("{\"pageSize\":1000}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 {
+		t.Errorf("Query Proposals returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "The pageSize must be between 1 and 100."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestGetProposalHistory(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.TxTime = testTime + 2*60*60
+	args = [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	args = [][]byte{[]byte("getProposalHistory"), []byte("prop1234")}
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 200 {
+		t.Errorf("Get Proposal History returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	history := []proposalHistoryEntry{}
+	err := json.Unmarshal(res.Payload, &history)
+	if err != nil {
+		t.Error("Error parsing proposal history - " + err.Error())
+	}
+	if len(history) != 2 {
+		t.Fatalf("Proposal history returned %d entries, but expected 2.", len(history))
+	}
+	statuses := []string{PendingStatus, InvalidatedStatus}
+	for i, entry := range history {
+		proposal := proposalEntry{}
+		err = json.Unmarshal(entry.Proposal, &proposal)
+		if err != nil {
+			t.Error("Error parsing proposal in history - " + err.Error())
+		}
+		if proposal.Status != statuses[i] {
+			t.Errorf("Proposal history entry %d has status %s, but expected %s.", i, proposal.Status, statuses[i])
+		}
+	}
+	if history[1].TxID != "txid2" || history[1].Timestamp != testTime+2*60*60 {
+		t.Errorf("Proposal history entry for invalidation was %+v.", history[1])
+	}
+}
+
+func TestGetProposalHistoryNoSuchProposal(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	args := [][]byte{[]byte("getProposalHistory"), []byte("prop1234")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 {
+		t.Errorf("Get Proposal History returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "No such proposal."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestGetProposalsByHash(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	//Two legs sharing a hash, whatever their ids, and an unrelated proposal
+	proposals := []struct{ id, alg string }{
+		{"alice-1", "SHA256"},
+		{"relayed-xyz", "SHA256"},
+		{"other", "SHA512"},
+	}
+	for _, p := range proposals {
+		testProposal := "{\"proposalId\": \"" + p.id + "\", \"proposalHandler\": \"Bob\"}"
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes[p.alg]), []byte(p.alg)}
+		res := stub.MockInvoke("txid-"+p.id, args)
+		if res.Status != 200 {
+			t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+		}
+	}
+	//The hash can be given in any of the accepted forms
+	queries := [][][]byte{
+		{[]byte("getProposalsByHash"), []byte(testHashes["SHA256"])},
+		{[]byte("getProposalsByHash"), []byte("0x" + strings.ToUpper(testHashes["SHA256"]))},
+		{[]byte("getProposalsByHash"), []byte("a3CoIOuXiIL6SbGZyFOlZ25eGkdENxvlr/1LOvH13eY="), []byte("base64")},
+	}
+	for _, args := range queries {
+		res := stub.MockInvoke("txid1", args)
+		if res.Status != 200 {
+			t.Errorf("Get Proposals By Hash returned non-OK status, got: %d, want: %d.", res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+		}
+		found := []proposalEntry{}
+		err := json.Unmarshal(res.Payload, &found)
+		if err != nil {
+			t.Error("Error parsing query response - " + err.Error())
+		}
+		if len(found) != 2 || found[0].Proposal.ProposalID != "alice-1" || found[1].Proposal.ProposalID != "relayed-xyz" {
+			t.Errorf("Get proposals by hash returned %s, but expected alice-1 and relayed-xyz.", string(res.Payload))
+		}
+	}
+	//An unused hash finds nothing
+	args := [][]byte{[]byte("getProposalsByHash"), []byte(testHashes["SHA384"])}
+	res := stub.MockInvoke("txid2", args)
+	if string(res.Payload) != "[]" {
+		t.Errorf("Get proposals by hash returned %s, but expected no proposals.", string(res.Payload))
+	}
+}
+
+func TestCreateProposalRefusesHashReuse(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	testProposal := "{" +
+		"\"proposalId\": \"prop5678\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"refuseHashReuse\":true}")}
+	res := stub.MockInvoke("txid2", args)
+	expectedMessage := ErrHashReused + ": A proposal with this hash already exists."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	//Reuse is allowed unless asked otherwise, or configured for the channel
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	res = stub.MockInit("txid4", [][]byte{[]byte("init"), []byte("{\"refuseHashReuse\":true}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	testProposal = "{" +
+		"\"proposalId\": \"prop9012\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid5", args)
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestInitIndexesExistingProposals(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	//A proposal stored before the hash index was introduced
+	legacyProposal := "{\"proposal\":{\"proposalId\":\"legacy\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"," +
+		"\"hash\":\"" + strings.ToUpper(testHashes["SHA256"]) + "\",\"hashAlgorithm\":\"SHA256\"}"
+	stub.MockTransactionStart("txid0")
+	stub.PutState(proposalPrefix+"legacy", []byte(legacyProposal))
+	stub.MockTransactionEnd("txid0")
+	res := stub.MockInit("txid1", [][]byte{[]byte("init")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	args := [][]byte{[]byte("getProposalsByHash"), []byte(testHashes["SHA256"])}
+	res = stub.MockInvoke("txid2", args)
+	found := []proposalEntry{}
+	err := json.Unmarshal(res.Payload, &found)
+	if err != nil || len(found) != 1 || found[0].Proposal.ProposalID != "legacy" {
+		t.Errorf("Get proposals by hash returned %s, but expected the legacy proposal.", string(res.Payload))
+	}
+}
+
+func TestInitIndexesExistingProposalsOnce(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//A proposal which somehow escaped the index is left alone by later
+	//upgrades, rather than every proposal being rewritten on each one
+	unindexed := "{\"proposal\":{\"proposalId\":\"unindexed\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"," +
+		"\"hash\":\"" + testHashes["SHA256"] + "\",\"hashAlgorithm\":\"SHA256\"}"
+	stub.MockTransactionStart("txid1")
+	stub.PutState(proposalPrefix+"unindexed", []byte(unindexed))
+	stub.MockTransactionEnd("txid1")
+	res = stub.MockInit("txid2", [][]byte{[]byte("init")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	args := [][]byte{[]byte("getProposalsByHash"), []byte(testHashes["SHA256"])}
+	res = stub.MockInvoke("txid3", args)
+	if string(res.Payload) != "[]" {
+		t.Errorf("Get proposals by hash returned %s, but expected the index not to be rebuilt.", string(res.Payload))
+	}
+}
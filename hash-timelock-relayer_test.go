@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/relayer"
+)
+
+//newTestRelayer sets up two channels, with Bob relaying proposals for him
+//in channel one to Charlie in channel two, and back to Alice
+func newTestRelayer(t *testing.T) (*relayer.Relayer, *testStub, *testStub) {
+	channelOne := newTestStub("channelOne", new(HashTimeLockContract))
+	channelOne.ChannelID = "channelOne"
+	channelTwo := newTestStub("channelTwo", new(HashTimeLockContract))
+	channelTwo.ChannelID = "channelTwo"
+	bob := newTestIdentity(t, "Bob", nil)
+	r, err := relayer.New(
+		relayer.Channel{Name: "channelOne", Ledger: &testLedger{stub: channelOne, creator: bob}, Handler: "Bob", ForwardHandler: "Alice"},
+		relayer.Channel{Name: "channelTwo", Ledger: &testLedger{stub: channelTwo, creator: bob}, Handler: "Bob", ForwardHandler: "Charlie"},
+		time.Hour,
+	)
+	if err != nil {
+		t.Fatalf("Relayer creation failed - %s", err.Error())
+	}
+	return r, channelOne, channelTwo
+}
+
+//relayNextEvent passes the next event fired in the channel to the relayer
+func relayNextEvent(t *testing.T, r *relayer.Relayer, channel *testStub) {
+	ccEvent := nextTestEvent(t, channel)
+	err := r.HandleEvent(channel.ChannelID, ledger.Event{TxID: ccEvent.TxId, Name: ccEvent.EventName, Payload: ccEvent.Payload})
+	if err != nil {
+		t.Errorf("Relayer failed to handle event from %s - %s", channel.ChannelID, err.Error())
+	}
+}
+
+//getTestProposal retrieves a proposal stored in the channel
+func getTestProposal(t *testing.T, channel *testStub, proposalID string) proposalEntry {
+	proposal := proposalEntry{}
+	proposalAsBytes, err := channel.GetState(proposalPrefix + proposalID)
+	if err != nil || proposalAsBytes == nil {
+		t.Fatalf("Error getting proposal %s from %s", proposalID, channel.ChannelID)
+	}
+	err = json.Unmarshal(proposalAsBytes, &proposal)
+	if err != nil {
+		t.Fatalf("Error parsing proposal %s from %s - %s", proposalID, channel.ChannelID, err.Error())
+	}
+	return proposal
+}
+
+func TestRelayerConfirmsAcrossChannels(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+	preImage := "test_hash"
+	hash := "5a32f0967623012cdd4c29257f808f3f209184e992c39dc6d931f89831e7b1eb9379f9e3a20da09eb06d0ca53bd9c0845dda91baed17a713c0cac8a24259c0b9"
+
+	//Alice proposes to Bob in channel one, which the relayer mirrors to Charlie
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA512"), []byte("{\"timelock\":\"2h\"}")}
+	res := channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal channel one returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	relayNextEvent(t, r, channelOne)
+	mirrored := getTestProposal(t, channelTwo, "prop1234")
+	if mirrored.Proposal.Handler != "Charlie" || mirrored.Status != PendingStatus || mirrored.Hash != hash {
+		t.Errorf("Relayer mirrored proposal %+v, but expected a pending proposal for Charlie.", mirrored)
+	}
+	if mirrored.Expiry != testTime+60*60 {
+		t.Errorf("Relayer mirrored proposal with expiry %d, but expected %d.", mirrored.Expiry, testTime+60*60)
+	}
+	//The relayer ignores the creation of its own proposal
+	relayNextEvent(t, r, channelTwo)
+
+	//Charlie confirms in channel two, which the relayer replays into channel one
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = channelTwo.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal channel two returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	relayNextEvent(t, r, channelTwo)
+	original := getTestProposal(t, channelOne, "prop1234")
+	if original.Status != ConfirmStatus {
+		t.Errorf("Relayer left proposal in channel one as %s, but expected %s.", original.Status, ConfirmStatus)
+	}
+	//Relaying the confirmation again is harmless
+	relayNextEvent(t, r, channelOne)
+	err := r.HandleEvent("channelTwo", ledger.Event{Name: events.Name,
+		Payload: []byte("{\"events\":[{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Charlie\",\"preImage\":\"test_hash\"}]}")})
+	if err != nil {
+		t.Errorf("Relayer failed to handle repeated confirmation - %s", err.Error())
+	}
+}
+
+func TestRelayerUnwindsRejection(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"timelock\":\"2h\"}")}
+	res := channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal channel one returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	relayNextEvent(t, r, channelOne)
+	relayNextEvent(t, r, channelTwo)
+
+	//Charlie rejects in channel two, so the relayer rejects in channel one
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
+	args = [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("Insufficient funds")}
+	res = channelTwo.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Reject Proposal channel two returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	relayNextEvent(t, r, channelTwo)
+	original := getTestProposal(t, channelOne, "prop1234")
+	if original.Status != RejectedStatus {
+		t.Errorf("Relayer left proposal in channel one as %s, but expected %s.", original.Status, RejectedStatus)
+	}
+	expectedReason := "The relayed proposal in channel channelTwo was not confirmed - Insufficient funds"
+	if original.Rejection == nil || original.Rejection.Reason != expectedReason || original.Rejection.MSPID != "Bob" {
+		t.Errorf("Relayer recorded rejection %+v, but expected reason: %s.", original.Rejection, expectedReason)
+	}
+}
+
+func TestRelayerCatchesUpAfterRestart(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"timelock\":\"2h\"}")}
+	res := channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal channel one returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//The relayer is down, so misses the event, but picks the proposal up on
+	//start
+	nextTestEvent(t, channelOne)
+	err := r.Rescan()
+	if err != nil {
+		t.Errorf("Relayer failed to rescan - %s", err.Error())
+	}
+	mirrored := getTestProposal(t, channelTwo, "prop1234")
+	if mirrored.Proposal.Handler != "Charlie" || mirrored.Status != PendingStatus {
+		t.Errorf("Relayer mirrored proposal %+v, but expected a pending proposal for Charlie.", mirrored)
+	}
+	nextTestEvent(t, channelTwo)
+
+	//Charlie confirms while the relayer is down again
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")}
+	res = channelTwo.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal channel two returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	nextTestEvent(t, channelTwo)
+	err = r.Rescan()
+	if err != nil {
+		t.Errorf("Relayer failed to rescan - %s", err.Error())
+	}
+	original := getTestProposal(t, channelOne, "prop1234")
+	if original.Status != ConfirmStatus {
+		t.Errorf("Relayer left proposal in channel one as %s, but expected %s.", original.Status, ConfirmStatus)
+	}
+}
+
+func TestRelayerInvalidHandlers(t *testing.T) {
+	channelLedger := &testLedger{stub: newTestStub("channelOne", new(HashTimeLockContract))}
+	_, err := relayer.New(
+		relayer.Channel{Name: "channelOne", Ledger: channelLedger, Handler: "Bob", ForwardHandler: "Bob"},
+		relayer.Channel{Name: "channelTwo", Ledger: channelLedger, Handler: "Bob", ForwardHandler: "Charlie"},
+		time.Hour,
+	)
+	if err == nil {
+		t.Error("Relayer creation succeeded with the same handler and forward handler, but expected an error.")
+	}
+}
+
+func TestRelayerAcceptsOriginals(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+
+	//Alice requires Bob to accept before confirming
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"),
+		[]byte("{\"timelock\":\"2h\",\"requireAcceptance\":true}")}
+	res := channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal channel one returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	relayNextEvent(t, r, channelOne)
+	original := getTestProposal(t, channelOne, "prop1234")
+	if original.Status != AcceptedStatus {
+		t.Errorf("Relayer left proposal in channel one as %s, but expected %s.", original.Status, AcceptedStatus)
+	}
+	relayNextEvent(t, r, channelOne)
+	relayNextEvent(t, r, channelTwo)
+
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
+	res = channelTwo.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Fatalf("Confirm Proposal channel two returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	relayNextEvent(t, r, channelTwo)
+	original = getTestProposal(t, channelOne, "prop1234")
+	if original.Status != ConfirmStatus {
+		t.Errorf("Relayer left proposal in channel one as %s, but expected %s.", original.Status, ConfirmStatus)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Multi-hop routing. A proposal can carry a route, the ordered list of hops
+ * a payment takes from the originating channel to the final recipient. Each
+ * hop names a channel, the handler of the proposal in that channel, and its
+ * expiry, e.g. for Alice paying Dave through Bob and Charlie:
+ *
+ *   "route": [{"channel": "ab", "proposalHandler": "Bob", "expiry": 1500014400},
+ *             {"channel": "bc", "proposalHandler": "Charlie", "expiry": 1500010800},
+ *             {"channel": "cd", "proposalHandler": "Dave", "expiry": 1500007200}]
+ *
+ * The same route is carried by the proposal at every hop, and the proposal in
+ * each channel is handled by, and expires with, the hop for that channel. The
+ * pre-image is released at the last hop, then replayed back up the route, so
+ * each upstream hop must expire at least routeSafetyDelta after the one below
+ * it. Otherwise an intermediary could pay out downstream, then find that the
+ * proposal paying them has already expired.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//validateRoute checks that each hop of a route is complete, and that the
+//route passes through each channel only once
+func validateRoute(route []routeHop) error {
+	if len(route) < 2 {
+		return codedError{ErrInvalidRoute, "A route must have at least two hops."}
+	}
+	channels := map[string]bool{}
+	for _, hop := range route {
+		if hop.Channel == "" || hop.Handler == "" {
+			return codedError{ErrInvalidRoute, "Each hop of the route must have a channel and proposalHandler."}
+		}
+		if hop.Expiry <= 0 {
+			return codedError{ErrInvalidRoute, fmt.Sprintf("The hop of the route in channel %s must have an expiry.", hop.Channel)}
+		}
+		if channels[hop.Channel] {
+			return codedError{ErrInvalidRoute, fmt.Sprintf("The route passes through channel %s more than once.", hop.Channel)}
+		}
+		channels[hop.Channel] = true
+	}
+	return nil
+}
+
+//resolveRouteExpiry works out the expiry of a routed proposal, which is the
+//expiry of the hop for this channel. The proposal must be handled by the
+//handler of that hop, and the timeouts along the route must be safely
+//ordered.
+func resolveRouteExpiry(stub shim.ChaincodeStubInterface, definition proposalDefinition, options createOptions, safetyDelta int64) (int64, error) {
+	if options.Timelock != "" || options.Expiry != "" {
+		return 0, codedError{ErrInvalidRoute, "The expiry of a routed proposal is taken from its route."}
+	}
+	route := definition.Route
+	for i := 1; i < len(route); i++ {
+		if route[i-1].Expiry-route[i].Expiry < safetyDelta {
+			return 0, codedError{ErrInvalidRoute, fmt.Sprintf("The hop in channel %s must expire at least %d seconds after the hop in channel %s.",
+				route[i-1].Channel, safetyDelta, route[i].Channel)}
+		}
+	}
+	channelID := stub.GetChannelID()
+	for _, hop := range route {
+		if hop.Channel != channelID {
+			continue
+		}
+		if hop.Handler != definition.Handler {
+			return 0, codedError{ErrInvalidRoute, fmt.Sprintf("The proposalHandler must be %s, the handler of the hop in channel %s.", hop.Handler, channelID)}
+		}
+		txTime, err := getTxTime(stub)
+		if err != nil {
+			return 0, err
+		}
+		if hop.Expiry <= txTime {
+			return 0, codedError{ErrInvalidRoute, fmt.Sprintf("The hop in channel %s must expire after the transaction timestamp.", channelID)}
+		}
+		return hop.Expiry, nil
+	}
+	return 0, codedError{ErrInvalidRoute, fmt.Sprintf("The route doesn't pass through channel %s.", channelID)}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/relayer"
+)
+
+//testRoute has Alice pay Dave through Bob and Charlie, with the hops
+//expiring three, two and one hours after testTime
+const testRoute = "[{\"channel\":\"ab\",\"proposalHandler\":\"Bob\",\"expiry\":1500010800}," +
+	"{\"channel\":\"bc\",\"proposalHandler\":\"Charlie\",\"expiry\":1500007200}," +
+	"{\"channel\":\"cd\",\"proposalHandler\":\"Dave\",\"expiry\":1500003600}]"
+
+//newTestRouters sets up the channels of testRoute, with Bob routing between
+//ab and bc, and Charlie routing between bc and cd
+func newTestRouters(t *testing.T) (map[string]*testStub, map[string]*relayer.Router) {
+	stubs := map[string]*testStub{}
+	for _, name := range []string{"ab", "bc", "cd"} {
+		stubs[name] = newTestStub(name, new(HashTimeLockContract))
+		stubs[name].ChannelID = name
+	}
+	routers := map[string]*relayer.Router{}
+	for intermediary, channels := range map[string][2]string{"Bob": {"ab", "bc"}, "Charlie": {"bc", "cd"}} {
+		identity := newTestIdentity(t, intermediary, nil)
+		r, err := relayer.NewRouter(
+			relayer.Channel{Name: channels[0], Ledger: &testLedger{stub: stubs[channels[0]], creator: identity}, Handler: intermediary},
+			relayer.Channel{Name: channels[1], Ledger: &testLedger{stub: stubs[channels[1]], creator: identity}, Handler: intermediary},
+		)
+		if err != nil {
+			t.Fatalf("Router creation failed - %s", err.Error())
+		}
+		routers[intermediary] = r
+	}
+	return stubs, routers
+}
+
+//routeNextEvent passes the next event fired in the channel to the router
+func routeNextEvent(t *testing.T, r *relayer.Router, channel *testStub) {
+	ccEvent := nextTestEvent(t, channel)
+	err := r.HandleEvent(channel.ChannelID, ledger.Event{TxID: ccEvent.TxId, Name: ccEvent.EventName, Payload: ccEvent.Payload})
+	if err != nil {
+		t.Errorf("Router failed to handle event from %s - %s", channel.ChannelID, err.Error())
+	}
+}
+
+//createTestRoutedProposal has Alice send prop1234 along testRoute, and has it
+//forwarded to Dave
+func createTestRoutedProposal(t *testing.T, stubs map[string]*testStub, routers map[string]*relayer.Router) {
+	stubs["ab"].Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"route\":" + testRoute + "}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stubs["ab"].MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	routeNextEvent(t, routers["Bob"], stubs["ab"])
+	routeNextEvent(t, routers["Charlie"], stubs["bc"])
+	nextTestEvent(t, stubs["cd"])
+	for channel, expected := range map[string]struct {
+		handler string
+		expiry  int64
+	}{"ab": {"Bob", testTime + 3*60*60}, "bc": {"Charlie", testTime + 2*60*60}, "cd": {"Dave", testTime + 60*60}} {
+		proposal := getTestProposal(t, stubs[channel], "prop1234")
+		if proposal.Proposal.Handler != expected.handler || proposal.Expiry != expected.expiry || proposal.Status != PendingStatus {
+			t.Errorf("Routed proposal in %s is %+v, but expected it pending for %s, expiring at %d.", channel, proposal, expected.handler, expected.expiry)
+		}
+	}
+}
+
+func TestRoutedProposalConfirmsEveryHop(t *testing.T) {
+	stubs, routers := newTestRouters(t)
+	createTestRoutedProposal(t, stubs, routers)
+
+	//Dave reveals the pre-image, which is replayed back up the route
+	stubs["cd"].Creator = newTestIdentity(t, "Dave", nil)
+	res := stubs["cd"].MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Fatalf("Confirm Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	routeNextEvent(t, routers["Charlie"], stubs["cd"])
+	routeNextEvent(t, routers["Bob"], stubs["bc"])
+	for _, channel := range []string{"ab", "bc", "cd"} {
+		if status := getTestProposal(t, stubs[channel], "prop1234").Status; status != ConfirmStatus {
+			t.Errorf("Routed proposal in %s is %s, but expected %s.", channel, status, ConfirmStatus)
+		}
+	}
+}
+
+func TestRoutedProposalUnwindsEveryHop(t *testing.T) {
+	stubs, routers := newTestRouters(t)
+	createTestRoutedProposal(t, stubs, routers)
+
+	stubs["cd"].Creator = newTestIdentity(t, "Dave", nil)
+	res := stubs["cd"].MockInvoke("txid2", [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("Unknown payer")})
+	if res.Status != 200 {
+		t.Fatalf("Reject Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	routeNextEvent(t, routers["Charlie"], stubs["cd"])
+	routeNextEvent(t, routers["Bob"], stubs["bc"])
+	for _, channel := range []string{"ab", "bc", "cd"} {
+		if status := getTestProposal(t, stubs[channel], "prop1234").Status; status != RejectedStatus {
+			t.Errorf("Routed proposal in %s is %s, but expected %s.", channel, status, RejectedStatus)
+		}
+	}
+}
+
+func TestRouterCatchesUpAfterRestart(t *testing.T) {
+	stubs, routers := newTestRouters(t)
+	stubs["ab"].Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"route\":" + testRoute + "}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stubs["ab"].MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	//Bob's router was down, so missed the event
+	nextTestEvent(t, stubs["ab"])
+	err := routers["Bob"].Rescan()
+	if err != nil {
+		t.Errorf("Router failed to rescan - %s", err.Error())
+	}
+	forwarded := getTestProposal(t, stubs["bc"], "prop1234")
+	if forwarded.Proposal.Handler != "Charlie" || forwarded.Expiry != testTime+2*60*60 {
+		t.Errorf("Router forwarded %+v, but expected a proposal for Charlie expiring at %d.", forwarded, testTime+2*60*60)
+	}
+}
+
+func TestCreateRoutedProposalValidation(t *testing.T) {
+	invalid := map[string]string{
+		"[{\"channel\":\"ab\",\"proposalHandler\":\"Bob\",\"expiry\":1500010800}]":                                                                             "A route must have at least two hops.",
+		"[{\"channel\":\"ab\",\"proposalHandler\":\"Bob\",\"expiry\":1500010800},{\"channel\":\"ab\",\"proposalHandler\":\"Dave\",\"expiry\":1500003600}]":     "The route passes through channel ab more than once.",
+		"[{\"channel\":\"ab\",\"proposalHandler\":\"Bob\",\"expiry\":1500010800},{\"channel\":\"cd\",\"proposalHandler\":\"Dave\",\"expiry\":1500009000}]":     "The hop in channel ab must expire at least 3600 seconds after the hop in channel cd.",
+		"[{\"channel\":\"ab\",\"proposalHandler\":\"Charlie\",\"expiry\":1500010800},{\"channel\":\"cd\",\"proposalHandler\":\"Dave\",\"expiry\":1500003600}]": "The proposalHandler must be Charlie, the handler of the hop in channel ab.",
+		"[{\"channel\":\"bc\",\"proposalHandler\":\"Bob\",\"expiry\":1500010800},{\"channel\":\"cd\",\"proposalHandler\":\"Dave\",\"expiry\":1500003600}]":     "The route doesn't pass through channel ab.",
+	}
+	stub := newTestStub("ab", new(HashTimeLockContract))
+	stub.ChannelID = "ab"
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	for route, expectedMessage := range invalid {
+		testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"route\":" + route + "}"
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+		res := stub.MockInvoke("txid1", args)
+		if res.Status != 500 || res.Message != ErrInvalidRoute+": "+expectedMessage {
+			t.Errorf("Create Proposal with route %s returned status %d and error: %s, but expected: %s", route, res.Status, res.Message, expectedMessage)
+		}
+	}
+	//The expiry is set by the route
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"route\":" + testRoute + "}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid2", args)
+	expectedMessage := ErrInvalidRoute + ": The expiry of a routed proposal is taken from its route."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+
+	//A smaller safety delta can be configured
+	res = stub.MockInit("txid3", [][]byte{[]byte("init"), []byte(fmt.Sprintf("{\"routeSafetyDelta\":%d}", 30*60))})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	route := "[{\"channel\":\"ab\",\"proposalHandler\":\"Bob\",\"expiry\":1500010800},{\"channel\":\"cd\",\"proposalHandler\":\"Dave\",\"expiry\":1500009000}]"
+	testProposal = "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"route\":" + route + "}"
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid4", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/timeout"
+)
+
+func TestTimeoutWatcherInvalidatesExpiredProposals(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"timeoutServices\":[{\"mspId\":\"Ops\"}]}}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	dir, err := ioutil.TempDir("", "timeout")
+	if err != nil {
+		t.Fatalf("Error creating store directory - %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	watcher, err := timeout.New(&testLedger{stub: stub, creator: newTestIdentity(t, "Ops", nil)},
+		timeout.FileStore{Path: filepath.Join(dir, "schedule.json")},
+		timeout.Config{Grace: time.Duration(defaultClockSkewTolerance) * time.Second, PollInterval: time.Second})
+	if err != nil {
+		t.Fatalf("Timeout watcher creation failed - %s", err.Error())
+	}
+	err = watcher.Start()
+	if err != nil {
+		t.Fatalf("Timeout watcher failed to start - %s", err.Error())
+	}
+
+	//The watcher schedules the proposal from the creation event
+	createTestProposal(t, stub)
+	ccEvent := nextTestEvent(t, stub)
+	err = watcher.HandleEvent(ledger.Event{TxID: ccEvent.TxId, Name: ccEvent.EventName, Payload: ccEvent.Payload})
+	if err != nil {
+		t.Errorf("Timeout watcher failed to handle event - %s", err.Error())
+	}
+	deadline, ok := watcher.Scheduled("prop1234")
+	if !ok || deadline.Unix() != testTime+60*60+defaultClockSkewTolerance {
+		t.Errorf("Timeout watcher scheduled proposal at %d, but expected %d.", deadline.Unix(), testTime+60*60+defaultClockSkewTolerance)
+	}
+
+	//Once it is due, the watcher invalidates it
+	stub.TxTime = deadline.Unix() + 1
+	err = watcher.InvalidateDue()
+	if err != nil {
+		t.Errorf("Timeout watcher failed to invalidate - %s", err.Error())
+	}
+	proposal := getTestProposal(t, stub, "prop1234")
+	if proposal.Status != InvalidatedStatus || proposal.Invalidation.MSPID != "Ops" {
+		t.Errorf("Timeout watcher left proposal %+v, but expected it invalidated by Ops.", proposal)
+	}
+	if _, ok := watcher.Scheduled("prop1234"); ok {
+		t.Error("Timeout watcher kept the invalidated proposal scheduled.")
+	}
+}
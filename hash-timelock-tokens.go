@@ -0,0 +1,239 @@
+/*
+ * Fungible token ledger, so that proposals can lock real value. Balances are
+ * held per account and asset type, where the account of a client is the MSP
+ * of their organisation, in line with how proposal handlers are identified.
+ *
+ * A proposal with an amount locks that amount of its assetType from the
+ * account of its creator into escrow when it is created. Confirming the
+ * proposal releases the escrow to the beneficiary, which defaults to the
+ * organisation of the handler, while invalidating or rejecting it refunds
+ * the creator. Proposals can lock a unique asset instead, see
+ * hash-timelock-assets.go, and those with neither lock nothing.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+/*
+ * Creates new tokens - takes the account, the assetType and the amount, which
+ * is added to the balance of the account. Only the minters in the access
+ * policy can mint.
+ */
+func (s *HashTimeLockContract) mint(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 3, the account, the asset type and the amount
+	if len(args) != 3 {
+		return shim.Error("Invalid arguments to mint, expected account, assetType and amount.")
+	}
+	amount, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("An account and assetType must be provided.")
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canMint(caller) {
+		return shim.Error("The transaction creator is not permitted to mint tokens.")
+	}
+	err = creditBalance(stub, args[0], args[1], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Moves tokens from the account of the transaction creator - takes the
+ * account to credit, the assetType and the amount.
+ */
+func (s *HashTimeLockContract) transfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 3, the recipient, the asset type and the amount
+	if len(args) != 3 {
+		return shim.Error("Invalid arguments to transfer, expected recipient, assetType and amount.")
+	}
+	amount, err := parseAmount(args[2])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if args[0] == "" || args[1] == "" {
+		return shim.Error("A recipient and assetType must be provided.")
+	}
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//A peer doesn't read back the writes of its own transaction, so crediting
+	//the account just debited would mint the amount
+	if args[0] == caller.MSPID {
+		return shim.Error("The recipient must be another account.")
+	}
+	err = debitBalance(stub, caller.MSPID, args[1], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = creditBalance(stub, args[0], args[1], amount)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Returns the balance of an account for an assetType, as a JSON tokenBalance.
+ * Tokens locked in escrow aren't included.
+ */
+func (s *HashTimeLockContract) balanceOf(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	//Validate the args, expect 2, the account and the asset type
+	if len(args) != 2 {
+		return shim.Error("Invalid arguments to balanceOf, expected account and assetType.")
+	}
+	balance, err := getBalance(stub, args[0], args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	balanceAsBytes, err := json.Marshal(tokenBalance{Account: args[0], AssetType: args[1], Balance: balance})
+	if err != nil {
+		return shim.Error("Error building balance - " + err.Error())
+	}
+	return shim.Success(balanceAsBytes)
+}
+
+//lockEscrow moves the amount or asset of a new proposal from the account of
+//its creator into escrow, returning the record of the escrow, or nil when the
+//proposal locks nothing
+func lockEscrow(stub shim.ChaincodeStubInterface, proposal proposalEntry, creator clientIdentity, policy accessPolicy) (*escrowRecord, error) {
+	definition := proposal.Proposal
+	if definition.Amount == 0 && definition.AssetID == "" {
+		return nil, nil
+	}
+	if definition.Originator != "" && definition.Originator != creator.MSPID {
+		return nil, codedError{ErrInvalidProposal, "The originator must be the organisation of the transaction creator."}
+	}
+	escrow := &escrowRecord{From: creator.MSPID, To: definition.Beneficiary, AssetType: definition.AssetType, Amount: definition.Amount,
+		AssetID: definition.AssetID}
+	if escrow.To == "" {
+		escrow.To = policy.handlerMSP(definition.Handler)
+	}
+	var err error
+	if escrow.AssetID != "" {
+		err = lockAsset(stub, escrow.AssetID, escrow.From, definition.ProposalID)
+	} else {
+		err = debitBalance(stub, escrow.From, escrow.AssetType, escrow.Amount)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return escrow, nil
+}
+
+//releaseEscrow pays the escrow of a confirmed proposal to the beneficiary
+func releaseEscrow(stub shim.ChaincodeStubInterface, escrow *escrowRecord) error {
+	if escrow == nil {
+		return nil
+	}
+	if escrow.AssetID != "" {
+		return unlockAsset(stub, escrow.AssetID, escrow.To)
+	}
+	return creditBalance(stub, escrow.To, escrow.AssetType, escrow.Amount)
+}
+
+//refundEscrow returns the escrow of an invalidated or rejected proposal to
+//its creator
+func refundEscrow(stub shim.ChaincodeStubInterface, escrow *escrowRecord) error {
+	if escrow == nil {
+		return nil
+	}
+	if escrow.AssetID != "" {
+		return unlockAsset(stub, escrow.AssetID, escrow.From)
+	}
+	return creditBalance(stub, escrow.From, escrow.AssetType, escrow.Amount)
+}
+
+//getBalance reads the balance of an account, which is zero if nothing has
+//been stored
+func getBalance(stub shim.ChaincodeStubInterface, account string, assetType string) (int64, error) {
+	key, err := stub.CreateCompositeKey(balanceIndex, []string{account, assetType})
+	if err != nil {
+		return 0, fmt.Errorf("Error building balance key - %s", err.Error())
+	}
+	balanceAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("Error retreiving balance from state - %s", err.Error())
+	}
+	if balanceAsBytes == nil {
+		return 0, nil
+	}
+	balance, err := strconv.ParseInt(string(balanceAsBytes), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing balance stored in state - %s", err.Error())
+	}
+	return balance, nil
+}
+
+//putBalance writes the balance of an account
+func putBalance(stub shim.ChaincodeStubInterface, account string, assetType string, balance int64) error {
+	key, err := stub.CreateCompositeKey(balanceIndex, []string{account, assetType})
+	if err != nil {
+		return fmt.Errorf("Error building balance key - %s", err.Error())
+	}
+	err = stub.PutState(key, []byte(strconv.FormatInt(balance, 10)))
+	if err != nil {
+		return fmt.Errorf("Error writing balance to state - %s", err.Error())
+	}
+	return nil
+}
+
+//creditBalance adds the amount to the balance of an account, refusing it if
+//the balance would overflow
+func creditBalance(stub shim.ChaincodeStubInterface, account string, assetType string, amount int64) error {
+	balance, err := getBalance(stub, account, assetType)
+	if err != nil {
+		return err
+	}
+	if balance > math.MaxInt64-amount {
+		return errors.New("The balance would overflow.")
+	}
+	return putBalance(stub, account, assetType, balance+amount)
+}
+
+//debitBalance takes the amount from the balance of an account, failing with
+//ErrInsufficientBalance if the account doesn't hold enough
+func debitBalance(stub shim.ChaincodeStubInterface, account string, assetType string, amount int64) error {
+	balance, err := getBalance(stub, account, assetType)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return codedError{ErrInsufficientBalance, fmt.Sprintf("%s holds %d %s, but %d are needed.", account, balance, assetType, amount)}
+	}
+	return putBalance(stub, account, assetType, balance-amount)
+}
+
+//parseAmount parses an amount of tokens, which must be positive
+func parseAmount(amount string) (int64, error) {
+	parsed, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Error parsing provided amount - %s", err.Error())
+	}
+	if parsed <= 0 {
+		return 0, errors.New("The amount must be positive.")
+	}
+	return parsed, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+//newTestTokenStub sets up a channel where Ops can mint, and mints 100 GBP
+//to Alice
+func newTestTokenStub(t *testing.T, name string) *testStub {
+	stub := newTestStub(name, new(HashTimeLockContract))
+	stub.ChannelID = name
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"minters\":[{\"mspId\":\"Ops\"}]}}")})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	mintTestTokens(t, stub, "Alice", "100")
+	return stub
+}
+
+func mintTestTokens(t *testing.T, stub *testStub, account string, amount string) {
+	stub.Creator = newTestIdentity(t, "Ops", nil)
+	res := stub.MockInvoke("txid-mint", [][]byte{[]byte("mint"), []byte(account), []byte("GBP"), []byte(amount)})
+	if res.Status != 200 {
+		t.Fatalf("Mint returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+}
+
+//checkTestBalance checks the GBP balance of the account
+func checkTestBalance(t *testing.T, stub *testStub, account string, expected int64) {
+	res := stub.MockInvoke("txid-balance", [][]byte{[]byte("balanceOf"), []byte(account), []byte("GBP")})
+	if res.Status != 200 {
+		t.Fatalf("Balance of returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	balance := tokenBalance{}
+	err := json.Unmarshal(res.Payload, &balance)
+	if err != nil {
+		t.Fatalf("Error parsing balance - %s", err.Error())
+	}
+	if balance.Balance != expected {
+		t.Errorf("%s holds %d GBP in %s, but expected %d.", account, balance.Balance, stub.ChannelID, expected)
+	}
+}
+
+//createTestEscrowProposal has Alice offer 60 GBP to Bob, leaving the event
+//fired in the channel
+func createTestEscrowProposal(t *testing.T, stub *testStub) {
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"assetType\":\"GBP\",\"amount\":60}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"timelock\":\"2h\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+}
+
+func TestMintAndTransfer(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res := stub.MockInvoke("txid1", [][]byte{[]byte("transfer"), []byte("Bob"), []byte("GBP"), []byte("30")})
+	if res.Status != 200 {
+		t.Errorf("Transfer returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	checkTestBalance(t, stub, "Alice", 70)
+	checkTestBalance(t, stub, "Bob", 30)
+
+	//Nobody can spend more than they hold
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("transfer"), []byte("Bob"), []byte("GBP"), []byte("71")})
+	expectedMessage := ErrInsufficientBalance + ": Alice holds 70 GBP, but 71 are needed."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Transfer returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("transfer"), []byte("Bob"), []byte("GBP"), []byte("-5")})
+	if res.Status != 500 || res.Message != "The amount must be positive." {
+		t.Errorf("Transfer of a negative amount returned status %d and error: %s", res.Status, res.Message)
+	}
+
+	//Only minters can mint
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("mint"), []byte("Alice"), []byte("GBP"), []byte("1000")})
+	expectedMessage = "The transaction creator is not permitted to mint tokens."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Mint returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	checkTestBalance(t, stub, "Alice", 70)
+}
+
+func TestTransferToSelf(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res := stub.MockInvoke("txid1", [][]byte{[]byte("transfer"), []byte("Alice"), []byte("GBP"), []byte("30")})
+	expectedMessage := "The recipient must be another account."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Transfer to self returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	checkTestBalance(t, stub, "Alice", 100)
+}
+
+func TestEscrowReleasedOnConfirm(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	createTestEscrowProposal(t, stub)
+	nextTestEvent(t, stub)
+	checkTestBalance(t, stub, "Alice", 40)
+	proposal := getTestProposal(t, stub, "prop1234")
+	expectedEscrow := escrowRecord{From: "Alice", To: "Bob", AssetType: "GBP", Amount: 60}
+	if proposal.Escrow == nil || *proposal.Escrow != expectedEscrow {
+		t.Errorf("Create proposal recorded escrow %+v, but expected %+v.", proposal.Escrow, expectedEscrow)
+	}
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	checkTestBalance(t, stub, "Alice", 40)
+	checkTestBalance(t, stub, "Bob", 60)
+
+	//Confirming again can't pay out a second time
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	expectedMessage := "Only pending proposals can be confirmed."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	checkTestBalance(t, stub, "Bob", 60)
+}
+
+func TestEscrowRefundedOnInvalidate(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	createTestEscrowProposal(t, stub)
+	nextTestEvent(t, stub)
+	stub.TxTime = testTime + 2*60*60 + defaultClockSkewTolerance + 1
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("invalidateProposal"), []byte("prop1234")})
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	checkTestBalance(t, stub, "Alice", 100)
+	checkTestBalance(t, stub, "Bob", 0)
+}
+
+func TestCreateProposalInsufficientBalance(t *testing.T) {
+	stub := newTestTokenStub(t, "mockChaincodeStub")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"assetType\":\"GBP\",\"amount\":101}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 || !strings.HasPrefix(res.Message, ErrInsufficientBalance+": ") {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected %s.", res.Status, res.Message, ErrInsufficientBalance)
+	}
+	//Nobody can lock tokens on behalf of another organisation
+	testProposal = "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"originator\":\"Charlie\",\"assetType\":\"GBP\",\"amount\":10}"
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid2", args)
+	expectedMessage := ErrInvalidProposal + ": The originator must be the organisation of the transaction creator."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestRelayerSwapsTokensAcrossChannels(t *testing.T) {
+	r, channelOne, channelTwo := newTestRelayer(t)
+	for _, channel := range []*testStub{channelOne, channelTwo} {
+		res := channel.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"minters\":[{\"mspId\":\"Ops\"}]}}")})
+		if res.Status != 200 {
+			t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+		}
+	}
+	mintTestTokens(t, channelOne, "Alice", "100")
+	mintTestTokens(t, channelTwo, "Bob", "100")
+
+	//Alice pays Bob in channel one, and the relayer has Bob pay Charlie the
+	//same amount in channel two
+	createTestEscrowProposal(t, channelOne)
+	relayNextEvent(t, r, channelOne)
+	relayNextEvent(t, r, channelTwo)
+	checkTestBalance(t, channelOne, "Alice", 40)
+	checkTestBalance(t, channelTwo, "Bob", 40)
+
+	//Charlie claims the payment in channel two, so the relayer claims Bob's
+	//payment in channel one
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
+	res := channelTwo.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal channel two returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	relayNextEvent(t, r, channelTwo)
+	checkTestBalance(t, channelTwo, "Charlie", 60)
+	checkTestBalance(t, channelOne, "Bob", 60)
+}
@@ -0,0 +1,46 @@
+/*
+ * Secrets passed in the transient data. Arguments are recorded in the
+ * transaction proposal, which every endorser and orderer sees, and which is
+ * kept in the block whether or not the transaction is valid. Transient data
+ * is passed to the chaincode, but never recorded, so the proposal definition
+ * given to createProposal, and the pre-image given to confirmProposal, can be
+ * left as empty arguments and supplied in the transient data instead, under
+ * the keys below, e.g.
+ *
+ *   peer chaincode invoke -c '{"Args":["confirmProposal","prop1234",""]}' \
+ *     --transient "{\"preImage\":\"$(echo -n test_hash | base64)\"}"
+ *
+ * The pre-image is still reported in the CONFIRMATION event, and stored with
+ * the proposal, once it has been checked, so that it can be replayed.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//Keys of the transient data which can stand in for arguments
+const (
+	proposalTransientKey = "proposal"
+	preImageTransientKey = "preImage"
+)
+
+//transientArg gives the value of an argument, which is read from the
+//transient data under the key when the argument is left empty
+func transientArg(stub shim.ChaincodeStubInterface, arg string, key string) (string, error) {
+	if arg != "" {
+		return arg, nil
+	}
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("Error retreiving the transient data - %s", err.Error())
+	}
+	value, ok := transient[key]
+	if !ok || len(value) == 0 {
+		return "", fmt.Errorf("No %s was provided, either as an argument or in the transient data.", key)
+	}
+	return string(value), nil
+}
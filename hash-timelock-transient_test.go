@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransientProposalAndPreImage(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	stub.TransientMap = map[string][]byte{proposalTransientKey: []byte("{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}")}
+	args := [][]byte{[]byte("createProposal"), []byte(""), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	if handler := getTestProposal(t, stub, "prop1234").Proposal.Handler; handler != "Bob" {
+		t.Errorf("Create proposal stored the handler %s, but expected Bob.", handler)
+	}
+	nextTestEvent(t, stub)
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	stub.TransientMap = map[string][]byte{preImageTransientKey: []byte("test_hash")}
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("")})
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//Once it is valid, the pre-image is reported so it can be replayed
+	expectedEvent := "{\"events\":[{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"preImage\":\"test_hash\",\"preImageEncoding\":\"raw\"}]}"
+	confirmationEvent := nextTestEvent(t, stub)
+	if string(confirmationEvent.Payload) != expectedEvent {
+		t.Errorf("Confirm proposal fired event with payload %s, but expected %s.", string(confirmationEvent.Payload), expectedEvent)
+	}
+}
+
+func TestTransientPreImageWithEncoding(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	stub.TransientMap = map[string][]byte{preImageTransientKey: []byte("746573745f68617368")}
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(""), []byte("hex")})
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestTransientArgumentsMissing(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	args := [][]byte{[]byte("createProposal"), []byte(""), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	expectedMessage := "No proposal was provided, either as an argument or in the transient data."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	createTestProposal(t, stub)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res = stub.MockInvoke("txid2", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("")})
+	expectedMessage = "No preImage was provided, either as an argument or in the transient data."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestTransientPrivateProposal(t *testing.T) {
+	stub := newTestStub("channelOne", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"privateData\":{\"collection\":\"swapTerms\",\"memberMSPs\":[\"Alice\",\"Bob\"]}}")})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	//Nothing about the terms appears in the arguments or public state
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	stub.TransientMap = map[string][]byte{
+		proposalTransientKey: []byte("{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"),
+		saltTransientKey:     []byte(testSalt),
+	}
+	args := [][]byte{[]byte("createProposal"), []byte(""), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	if handler := getTestProposal(t, stub, "prop1234").Proposal.Handler; handler != "" {
+		t.Errorf("Create proposal stored the handler %s in public state.", handler)
+	}
+	if handler := getTestProposalAs(t, stub, "Bob", "prop1234").Proposal.Handler; handler != "Bob" {
+		t.Errorf("Get Proposal gave a member the handler %s, but expected Bob.", handler)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Business validation hooks. The contract runs each of its validators, in
+ * order, before a proposal changes state, and refuses the transaction with
+ * the error of the first validator which fails. This lets access checks,
+ * amount limits, handler allowlists and the like be composed when the
+ * contract is constructed, rather than written into the handlers, e.g.
+ *
+ *   shim.Start(newHashTimeLockContract(
+ *       handlerAllowlist{Handlers: map[string]bool{"Bob": true}},
+ *       amountLimits{Limits: map[string]int64{"GBP": 10000}},
+ *   ))
+ *
+ * Validators see the proposal as it will be stored, with the caller identity
+ * taken from the certificate of the transaction creator. They can read state
+ * through the stub, but shouldn't write to it.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//proposalValidator is called before each transition of a proposal. Returning
+//an error refuses the transition, with the error as the message, so codedError
+//can be used to give clients a code to act on.
+type proposalValidator interface {
+	//OnCreate is called before a new proposal is stored, once its expiry,
+	//creator and escrow have been set
+	OnCreate(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error
+	//OnAccept is called once the caller is known to be the handler of the
+	//pending proposal being accepted
+	OnAccept(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error
+	//OnConfirm is called before the pre-image is checked for a pending
+	//proposal, once the caller is known to be its handler
+	OnConfirm(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error
+	//OnInvalidate is called once a pending proposal has expired, and the
+	//caller is permitted to invalidate it
+	OnInvalidate(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error
+	//OnReject is called once the caller is known to be the handler of the
+	//pending proposal being rejected
+	OnReject(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error
+}
+
+//newHashTimeLockContract builds the contract, with the validators to run on
+//each transition
+func newHashTimeLockContract(validators ...proposalValidator) *HashTimeLockContract {
+	return &HashTimeLockContract{validators: validators}
+}
+
+//acceptAllValidator allows every transition. It can be embedded by
+//validators which are only interested in some of them.
+type acceptAllValidator struct{}
+
+//OnCreate allows the proposal to be created
+func (acceptAllValidator) OnCreate(shim.ChaincodeStubInterface, proposalEntry, clientIdentity) error {
+	return nil
+}
+
+//OnAccept allows the proposal to be accepted
+func (acceptAllValidator) OnAccept(shim.ChaincodeStubInterface, proposalEntry, clientIdentity) error {
+	return nil
+}
+
+//OnConfirm allows the proposal to be confirmed
+func (acceptAllValidator) OnConfirm(shim.ChaincodeStubInterface, proposalEntry, clientIdentity) error {
+	return nil
+}
+
+//OnInvalidate allows the proposal to be invalidated
+func (acceptAllValidator) OnInvalidate(shim.ChaincodeStubInterface, proposalEntry, clientIdentity) error {
+	return nil
+}
+
+//OnReject allows the proposal to be rejected
+func (acceptAllValidator) OnReject(shim.ChaincodeStubInterface, proposalEntry, clientIdentity) error {
+	return nil
+}
+
+//handlerAllowlist only allows proposals to be created for the Handlers it
+//holds
+type handlerAllowlist struct {
+	acceptAllValidator
+	Handlers map[string]bool
+}
+
+//OnCreate refuses proposals for handlers which aren't in the allowlist
+func (allowlist handlerAllowlist) OnCreate(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	if !allowlist.Handlers[proposal.Proposal.Handler] {
+		return codedError{ErrInvalidProposal, fmt.Sprintf("%s is not an approved proposal handler.", proposal.Proposal.Handler)}
+	}
+	return nil
+}
+
+//amountLimits caps the amount of each asset type which a single proposal can
+//lock. Asset types without a limit are unrestricted.
+type amountLimits struct {
+	acceptAllValidator
+	Limits map[string]int64
+}
+
+//OnCreate refuses proposals locking more than the limit for their asset type
+func (limits amountLimits) OnCreate(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	definition := proposal.Proposal
+	limit, ok := limits.Limits[definition.AssetType]
+	if ok && definition.Amount > limit {
+		return codedError{ErrInvalidProposal, fmt.Sprintf("Proposals can lock at most %d %s.", limit, definition.AssetType)}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//recordingValidator records the transitions it is called for, with the MSP
+//of the caller, and refuses the transition named in refuse
+type recordingValidator struct {
+	calls  []string
+	refuse string
+}
+
+func (v *recordingValidator) record(transition string, caller clientIdentity) error {
+	v.calls = append(v.calls, transition+":"+caller.MSPID)
+	if transition == v.refuse {
+		return errors.New("Refused by the test validator.")
+	}
+	return nil
+}
+
+func (v *recordingValidator) OnCreate(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	return v.record("create", caller)
+}
+
+func (v *recordingValidator) OnAccept(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	return v.record("accept", caller)
+}
+
+func (v *recordingValidator) OnConfirm(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	return v.record("confirm", caller)
+}
+
+func (v *recordingValidator) OnInvalidate(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	return v.record("invalidate", caller)
+}
+
+func (v *recordingValidator) OnReject(stub shim.ChaincodeStubInterface, proposal proposalEntry, caller clientIdentity) error {
+	return v.record("reject", caller)
+}
+
+func TestValidatorsRunOnEachTransition(t *testing.T) {
+	validator := &recordingValidator{refuse: "confirm"}
+	stub := newTestStub("mockChaincodeStub", newHashTimeLockContract(validator))
+	createTestProposal(t, stub)
+	nextTestEvent(t, stub)
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("acceptProposal"), []byte("prop1234")})
+	if res.Status != 200 {
+		t.Errorf("Accept Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")})
+	if res.Status != 500 || res.Message != "Refused by the test validator." {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected the validator to refuse it.", res.Status, res.Message)
+	}
+	if getTestProposal(t, stub, "prop1234").Status != AcceptedStatus {
+		t.Errorf("A confirmation refused by a validator changed the proposal.")
+	}
+	res = stub.MockInvoke("txid4", [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("No thanks")})
+	if res.Status != 200 {
+		t.Errorf("Reject Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	expected := []string{"create:Alice", "accept:Bob", "confirm:Bob", "reject:Bob"}
+	if len(validator.calls) != len(expected) {
+		t.Fatalf("Validator was called for %v, but expected %v.", validator.calls, expected)
+	}
+	for i := range expected {
+		if validator.calls[i] != expected[i] {
+			t.Errorf("Validator was called for %v, but expected %v.", validator.calls, expected)
+			break
+		}
+	}
+}
+
+func TestValidatorsRunOnInvalidate(t *testing.T) {
+	validator := &recordingValidator{refuse: "invalidate"}
+	stub := newTestStub("mockChaincodeStub", newHashTimeLockContract(validator))
+	createTestProposal(t, stub)
+	nextTestEvent(t, stub)
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("invalidateProposal"), []byte("prop1234")})
+	if res.Status != 500 || res.Message != "Refused by the test validator." {
+		t.Errorf("Invalidate Proposal returned status %d and error: %s, but expected the validator to refuse it.", res.Status, res.Message)
+	}
+}
+
+func TestComposedValidators(t *testing.T) {
+	later := &recordingValidator{}
+	contract := newHashTimeLockContract(
+		handlerAllowlist{Handlers: map[string]bool{"Bob": true}},
+		amountLimits{Limits: map[string]int64{"GBP": 50}},
+		later,
+	)
+	stub := newTestStub("mockChaincodeStub", contract)
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"accessPolicy\":{\"minters\":[{\"mspId\":\"Ops\"}]}}")})
+	if res.Status != 200 {
+		t.Fatalf("Init returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	mintTestTokens(t, stub, "Alice", "100")
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+
+	refused := map[string]string{
+		"{\"proposalId\":\"prop1\",\"proposalHandler\":\"Mallory\"}":                                 ErrInvalidProposal + ": Mallory is not an approved proposal handler.",
+		"{\"proposalId\":\"prop2\",\"proposalHandler\":\"Bob\",\"assetType\":\"GBP\",\"amount\":60}": ErrInvalidProposal + ": Proposals can lock at most 50 GBP.",
+	}
+	for testProposal, expectedMessage := range refused {
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+		res = stub.MockInvoke("txid1", args)
+		if res.Status != 500 || res.Message != expectedMessage {
+			t.Errorf("Create Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+		}
+	}
+	//Validators after a refusal aren't run
+	if len(later.calls) != 0 {
+		t.Errorf("Validator was called for %v after an earlier validator refused.", later.calls)
+	}
+	testProposal := "{\"proposalId\":\"prop3\",\"proposalHandler\":\"Bob\",\"assetType\":\"GBP\",\"amount\":50}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	checkTestBalance(t, stub, "Alice", 50)
+}
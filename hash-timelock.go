@@ -3,31 +3,58 @@
  * abstract concept of a 'proposal', which can be placed into a pending state,
  * then is only confirmed through production of the pre-image.
  *
- * This code leaves a lot of sections empty, to be filled with appropriate
- * logic for whatever the concrete use case is.
+ * Business rules for the concrete use case are supplied as validators when
+ * the contract is constructed, see hash-timelock-validators.go.
  */
 
 package main
 
 import (
-	"crypto/sha256"
-	"crypto/sha512"
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"hash"
 	"strings"
+	"time"
+	"unicode/utf8"
 
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 	"github.com/hyperledger/fabric/protos/peer"
 )
 
-//HashTimeLockContract struct to define the smart contract object
+//HashTimeLockContract struct to define the smart contract object, holding
+//the validators run on each transition of a proposal, see
+//hash-timelock-validators.go
 type HashTimeLockContract struct {
+	validators []proposalValidator
 }
 
-//Init method for handling instantiation/upgrade
+//Init method for handling instantiation/upgrade. Optionally takes a JSON
+//contractConfig document, which replaces any configuration already stored,
+//see hash-timelock-config.go.
 func (s *HashTimeLockContract) Init(stub shim.ChaincodeStubInterface) peer.Response {
+	_, args := stub.GetFunctionAndParameters()
+	//Index any proposals stored before the hash index was introduced
+	err := migrateHashIndex(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if len(args) == 0 {
+		return shim.Success(nil)
+	}
+	if len(args) != 1 {
+		return shim.Error("Invalid arguments to Init, expected an optional configuration.")
+	}
+	config, err := parseConfig([]byte(args[0]))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putConfig(stub, config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
 	return shim.Success(nil)
 }
 
@@ -39,10 +66,52 @@ func (s *HashTimeLockContract) Invoke(stub shim.ChaincodeStubInterface) peer.Res
 	switch function {
 	case "createProposal":
 		return s.createProposal(stub, args)
+	case "createLinkedProposal":
+		return s.createLinkedProposal(stub, args)
+	case "linkProposal":
+		return s.linkProposal(stub, args)
 	case "confirmProposal":
 		return s.confirmProposal(stub, args)
 	case "invalidateProposal":
 		return s.invalidateProposal(stub, args)
+	case "acceptProposal":
+		return s.acceptProposal(stub, args)
+	case "rejectProposal":
+		return s.rejectProposal(stub, args)
+	case "pauseContract":
+		return s.pauseContract(stub, args)
+	case "resumeContract":
+		return s.resumeContract(stub, args)
+	case "freezeHandler":
+		return s.freezeHandler(stub, args)
+	case "unfreezeHandler":
+		return s.unfreezeHandler(stub, args)
+	case "getControls":
+		return s.getContractControls(stub, args)
+	case "setConfig":
+		return s.setConfig(stub, args)
+	case "getConfig":
+		return s.getContractConfig(stub, args)
+	case "getProposal":
+		return s.getProposal(stub, args)
+	case "queryProposals":
+		return s.queryProposals(stub, args)
+	case "getProposalHistory":
+		return s.getProposalHistory(stub, args)
+	case "getProposalsByHash":
+		return s.getProposalsByHash(stub, args)
+	case "mint":
+		return s.mint(stub, args)
+	case "transfer":
+		return s.transfer(stub, args)
+	case "balanceOf":
+		return s.balanceOf(stub, args)
+	case "createAsset":
+		return s.createAsset(stub, args)
+	case "transferAsset":
+		return s.transferAsset(stub, args)
+	case "ownerOf":
+		return s.ownerOf(stub, args)
 	default:
 		return shim.Error("Invalid Smart Contract function name.")
 	}
@@ -54,65 +123,190 @@ func (s *HashTimeLockContract) Invoke(stub shim.ChaincodeStubInterface) peer.Res
  * clients to handle things like automated invalidation (timelocking), alerting
  * of the intended middle-man consumer, etc.
  *
- * In this example, we support SHA256, SHA384 and SHA512, and expect the hash
- * to be provided as a hexadecimal string
+ * The hashing algorithm must be one of those in the registry, see
+ * hash-timelock-hashing.go. The hash is expected to be provided as a
+ * hexadecimal string, optionally 0x-prefixed, unless the options give another
+ * hashEncoding. It must be a digest of the algorithm, and is always stored as
+ * lowercase hexadecimal. The minimum pre-image length which will be accepted
+ * is recorded in the proposal, taken from the options or the configuration.
+ * Validation failures are prefixed with one of the error codes.
+ *
+ * The proposal can be left empty, and passed in the transient data instead,
+ * see hash-timelock-transient.go.
+ *
+ * Optionally takes a JSON createOptions object, which sets when the timelock
+ * expires. If omitted, the configured default timelock is applied. Setting
+ * retry in the options allows a submission to be safely repeated.
  *
  * Returns a proposal id - which here is taken from the proposal object, but
  * could be generated, etc...
  */
 func (s *HashTimeLockContract) createProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var err error
-	//Validate the args, expect 3, the proposal, the hash and the hashing algorithm
-	if len(args) != 3 {
-		return shim.Error("Invalid arguments to createProposal, expected proposal, hash, hashingAlg.")
+	//Validate the args, expect 3, the proposal, the hash and the hashing algorithm,
+	//plus optionally the options object
+	if len(args) != 3 && len(args) != 4 {
+		return shim.Error("Invalid arguments to createProposal, expected proposal, hash, hashingAlg and optionally options.")
 	}
+	return s.newProposal(stub, args, nil)
+}
+
+//newProposal creates a proposal from the arguments of createProposal. When a
+//link is given, the proposal is linked to its counterpart, and refused unless
+//it expires safely before the counterpart.
+func (s *HashTimeLockContract) newProposal(stub shim.ChaincodeStubInterface, args []string, link *counterpartLink) peer.Response {
+	var err error
 	//Check if it is a valid hashing algorithm
-	validAlg := false
-	for _, a := range validHashingAlgorithms {
-		if a == args[2] {
-			validAlg = true
-		}
+	if _, ok := hashAlgorithms[args[2]]; !ok {
+		return shim.Error(errUnsupportedHashAlgorithm().Error())
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.allowsHashAlgorithm(args[2]) {
+		return shim.Error(codedError{ErrUnsupportedHashAlgorithm, fmt.Sprintf("The %s hash algorithm is not allowed on this channel.", args[2])}.Error())
 	}
-	if validAlg == false {
-		return shim.Error("Only these hashing algorithms are supported: " + strings.Join(validHashingAlgorithms, ", "))
+	proposal := proposalEntry{Status: PendingStatus, Hash: args[1], HashAlgorithm: args[2]}
+	definition, err := transientArg(stub, args[0], proposalTransientKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if config.MaxProposalSize != 0 && len(definition) > config.MaxProposalSize {
+		return shim.Error(codedError{ErrInvalidProposal, fmt.Sprintf("The proposal is %d bytes, but can be at most %d bytes.", len(definition), config.MaxProposalSize)}.Error())
 	}
-	proposal := proposalEntry{Proposal: abstractProposal{}, Status: PendingStatus, Hash: args[1], HashAlgorithm: args[2]}
-	err = json.Unmarshal([]byte(args[0]), &proposal.Proposal)
+	proposal.Proposal, err = parseProposalDefinition([]byte(definition))
 	if err != nil {
 		return shim.Error("Error parsing provided proposal definition - " + err.Error())
 	}
-	if proposal.Proposal.ProposalID == "" {
-		return shim.Error("No proposalId provided as part of proposal.")
+	err = proposal.Proposal.validate()
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	if proposal.Proposal.Handler == "" {
-		//There should probably be a lot more validation of this handler - but we
-		//will just accept what is passed for this sample
-		return shim.Error("No proposalHandler provided as part of proposal.")
+	//Nothing new can be locked while the contract is paused, or for a frozen
+	//handler, see hash-timelock-controls.go
+	controls, err := getControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = controls.checkCreate(proposal.Proposal.Handler)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	options := createOptions{}
+	if len(args) == 4 {
+		err = json.Unmarshal([]byte(args[3]), &options)
+		if err != nil {
+			return shim.Error("Error parsing provided options - " + err.Error())
+		}
+	}
+	//Check the hash is a digest of the algorithm, normalising it so the same
+	//hash is always stored the same way
+	proposal.Hash, err = normaliseHash(args[1], options.HashEncoding, proposal.HashAlgorithm)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal.MinPreImageLength, err = resolveMinPreImageLength(options, config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal.RequireAcceptance = options.RequireAcceptance || config.RequireAcceptance
+	proposal.PreImageRecipient, err = resolvePreImageRecipient(options)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//Capture who is creating the proposal, if they are allowed to. This is
+	//checked first, so others can't probe which proposalIds exist.
+	creator, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canCreate(creator) {
+		return shim.Error("The transaction creator is not permitted to create proposals.")
+	}
+	//Existing proposals can never be overwritten. When retrying, resubmitting
+	//exactly what is already stored succeeds without changing anything.
+	existing, exists, err := getStoredProposal(stub, proposal.Proposal.ProposalID)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if exists {
+		if !options.Retry {
+			return shim.Error("A proposal with this proposalId already exists.")
+		}
+		if !isSameSubmission(existing, proposal) {
+			return shim.Error("A different proposal with this proposalId already exists.")
+		}
+		return shim.Success(nil)
+	}
+	//Refuse a hash which is already in use, if asked to
+	if config.RefuseHashReuse || options.RefuseHashReuse {
+		used, err := isHashUsed(stub, proposal.Hash)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if used {
+			return shim.Error(codedError{ErrHashReused, "A proposal with this hash already exists."}.Error())
+		}
+	}
+	//Work out when the timelock expires, which for a routed proposal is set by
+	//its hop of the route
+	if len(proposal.Proposal.Route) > 0 {
+		proposal.Expiry, err = resolveRouteExpiry(stub, proposal.Proposal, options, config.RouteSafetyDelta)
+	} else {
+		proposal.Expiry, err = resolveExpiry(stub, options, config)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = config.checkTimelock(stub, proposal.Expiry)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if link != nil {
+		err = checkLink(stub, proposal, *link, config.LinkSafetyMargin)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		proposal.Link = link
+	}
+	proposal.Creator = &creator
+	//Keep the proposal in the private data collection, if one is configured
+	proposal.Private, err = newPrivateReference(stub, config, proposal.Proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//Lock the amount of the proposal, if it has one
+	proposal.Escrow, err = lockEscrow(stub, proposal, creator, config.AccessPolicy)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//Validators see the escrow too. If one refuses, the transaction fails, so
+	//nothing written while locking it is committed.
+	for _, validator := range s.validators {
+		err = validator.OnCreate(stub, proposal, creator)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
-	/*
-	 * All of your awesome validation logic goes here - maybe we need access control,
-	 * maybe we need to validate the proposal handler is appropriate?
-	 */
 
 	//Write the proposal to state
-	proposalAsBytes, err := json.Marshal(proposal)
+	err = putStoredProposal(stub, proposal)
 	if err != nil {
-		return shim.Error("Error building proposal definition - " + err.Error())
+		return shim.Error(err.Error())
 	}
-	err = stub.PutState(proposalPrefix+proposal.Proposal.ProposalID, proposalAsBytes)
+	err = indexProposal(stub, proposal)
 	if err != nil {
-		return shim.Error("Error writing proposal to state - " + err.Error())
+		return shim.Error(err.Error())
 	}
-	//Fire appropriate events
-	proposalCreatedEvent := ProposalCreatedEventObject{ProposalID: proposal.Proposal.ProposalID}
-	proposalEventAsBytes, err := json.Marshal(proposalCreatedEvent)
+	//Fire appropriate events, for the provided handler and the timeout client
+	proposalCreatedEvent := events.Event{ProposalID: proposal.Proposal.ProposalID, Handler: eventHandler(proposal), Expiry: proposal.Expiry}
+	handlerEvent, timeoutEvent := proposalCreatedEvent, proposalCreatedEvent
+	handlerEvent.Type = events.HandlerNotification
+	timeoutEvent.Type = events.TimeoutRegistration
+	err = setProposalEvents(stub, handlerEvent, timeoutEvent)
 	if err != nil {
-		return shim.Error("Error building proposal event definition - " + err.Error())
+		return shim.Error(err.Error())
 	}
-	//Event for the provided handler
-	err = stub.SetEvent(proposal.Proposal.Handler+ProposalCreatedHandlerEvent, proposalEventAsBytes)
-	//Event for the timeout client
-	err = stub.SetEvent(ProposalCreateTimeoutEvent, proposalEventAsBytes)
 	return shim.Success(nil)
 }
 
@@ -122,82 +316,146 @@ func (s *HashTimeLockContract) createProposal(stub shim.ChaincodeStubInterface,
  * hash. Fires an event on this state transition, which is indended to
  * allow the middle-man to obtain the pre-image, then use that to confirm
  * the transaction in the other channel.
- * In most practical implementations, there would be some business specific
- * operations which would be performed due to this transition, but as this
- * sample is getting away with using the same contract in both places, it
- * doesn't do that here.
+ * The pre-image is taken as is, unless an encoding of hex or base64 is given,
+ * in which case it is decoded to the bytes which are hashed. The encoding is
+ * passed on in the event, so the exact same bytes can be replayed. The
+ * pre-image can be left empty, and passed in the transient data instead, see
+ * hash-timelock-transient.go. If the proposal names a recipient for the
+ * pre-image, it is reported encrypted, see hash-timelock-encryption.go.
+ * Any tokens locked by the proposal are released to its beneficiary. In most
+ * practical implementations, there would be further business specific
+ * operations performed due to this transition.
  */
 func (s *HashTimeLockContract) confirmProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	var err error
 	//Validate the args, expect 2, the proposalId and the pre-image of the hash
-	//for that proposalId
-	if len(args) != 2 {
-		return shim.Error("Invalid arguments to confirmProposal, expected proposalId, pre-image.")
+	//for that proposalId, plus optionally the encoding of the pre-image
+	if len(args) != 2 && len(args) != 3 {
+		return shim.Error("Invalid arguments to confirmProposal, expected proposalId, pre-image and optionally encoding.")
+	}
+	encoding := RawEncoding
+	if len(args) == 3 && args[2] != "" {
+		encoding = args[2]
+	}
+	preImageArg, err := transientArg(stub, args[1], preImageTransientKey)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	preImage, err := decodePreImage(preImageArg, encoding)
+	if err != nil {
+		return shim.Error(codedError{ErrInvalidPreImageEncoding, "Error decoding provided pre-image - " + err.Error()}.Error())
 	}
 	//Retreive the proposal referenced
-	proposalAsBytes, err := stub.GetState(proposalPrefix + args[0])
+	proposal, ok, err := getStoredProposal(stub, args[0])
 	if err != nil {
-		return shim.Error("Error while retreiving the stored proposal from state - " + err.Error())
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		return shim.Error("No such proposal.")
+	}
+	if proposal.Status == InvalidatedStatus {
+		return shim.Error("The proposal has expired and been invalidated.")
+	}
+	if proposal.Status == RejectedStatus {
+		return shim.Error("The proposal has been rejected by the handler.")
+	}
+	if !isOpen(proposal.Status) {
+		return shim.Error("Only pending proposals can be confirmed.")
 	}
-	if proposalAsBytes == nil {
-		return shim.Error("No such proposal. It may have expired and been invalidated.")
+	if proposal.RequireAcceptance && proposal.Status != AcceptedStatus {
+		return shim.Error(codedError{ErrNotAccepted, "The proposal must be accepted by the handler before it can be confirmed."}.Error())
 	}
-	proposal := proposalEntry{}
-	err = json.Unmarshal(proposalAsBytes, &proposal)
+	config, err := getConfig(stub)
 	if err != nil {
-		return shim.Error("Error while parsing the proposal stored in state - " + err.Error())
+		return shim.Error(err.Error())
 	}
 
-	/*
-	 * All of your awesome validation logic goes here - maybe we need to
-	 * validate the transaction creator matches the tagged handler?
-	 * Maybe we wrote time-bound conditions into the proposal, and can't
-	 * confirm it after the end of that window, even if the hash is valid?
-	 * (even though trusting timestamps in HLF is hard...)
-	 */
+	//Pre-images can't be accepted once the timelock has expired, even if they
+	//are valid. Proposals stored before expiries were recorded have none.
+	if proposal.Expiry != 0 {
+		expired, err := hasExpired(stub, proposal, config)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if expired {
+			return shim.Error("The timelock on this proposal has expired.")
+		}
+	}
+
+	//Only the organisation of the tagged handler can confirm
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canConfirm(caller, proposal) {
+		return shim.Error("Only the organisation of the proposal handler can confirm this proposal.")
+	}
+	controls, err := getControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = controls.checkConfirm(proposal.Proposal.Handler)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, validator := range s.validators {
+		err = validator.OnConfirm(stub, proposal, caller)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
 
 	//Validate whether the supplied pre-image is valid for this proposal
 	//Going to compare hexadecimal strings
-	var hasher hash.Hash
-	switch proposal.HashAlgorithm {
-	case "SHA256":
-		hasher = sha256.New()
-		break
-	case "SHA384":
-		hasher = sha512.New384()
-		break
-	case "SHA512":
-		hasher = sha512.New()
-		break
-	default:
+	hasher, ok := newHasher(proposal.HashAlgorithm)
+	if !ok {
 		return shim.Error("The hash algorithm which was recorded in the proposal is not supported.")
 	}
-	hasher.Write([]byte(args[1]))
+	if len(preImage) < proposal.MinPreImageLength {
+		return shim.Error(codedError{ErrPreImageTooShort, fmt.Sprintf("The pre-image must be at least %d bytes.", proposal.MinPreImageLength)}.Error())
+	}
+	hasher.Write(preImage)
 	if hex.EncodeToString(hasher.Sum(nil)) != strings.ToLower(proposal.Hash) {
 		return shim.Error("Invalid Pre-image supplied.")
 	}
-	//Mark the proposal as confirmed
+	//Mark the proposal as confirmed, paying out anything it locked
 	proposal.Status = ConfirmStatus
-	proposalAsBytes, err = json.Marshal(proposal)
+	//Keep the pre-image from everyone but the recipient, if it has one
+	if proposal.PreImageRecipient != "" {
+		proposal.PreImage, proposal.PreImageEncoding, err = sealPreImage(stub, proposal, preImage)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	} else {
+		proposal.PreImage, proposal.PreImageEncoding = encodePreImageForEvent(preImageArg, encoding)
+	}
+	err = releaseEscrow(stub, proposal.Escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putStoredProposal(stub, proposal)
 	if err != nil {
-		return shim.Error("Error when marshaling proposal - " + err.Error())
+		return shim.Error(err.Error())
 	}
-	err = stub.PutState(proposalPrefix+args[0], proposalAsBytes)
 	//Fire an event to inform middle actor to allow replaying into other channel
-	proposalConfirmedEvent := ProposalConfirmedEventObject{ProposalID: args[0], PreImage: args[1]}
-	proposalEventAsBytes, err := json.Marshal(proposalConfirmedEvent)
+	confirmationEvent := events.Event{Type: events.Confirmation, ProposalID: args[0], Handler: eventHandler(proposal)}
+	confirmationEvent.PreImage, confirmationEvent.PreImageEncoding = proposal.PreImage, proposal.PreImageEncoding
+	err = setProposalEvents(stub, confirmationEvent)
 	if err != nil {
-		return shim.Error("Error building proposal event definition - " + err.Error())
+		return shim.Error(err.Error())
 	}
-	err = stub.SetEvent(ProposalConfirmedHandlerEvent, proposalEventAsBytes)
 	return shim.Success(nil)
 }
 
 /*
- * Function that can be used to invalidate a proposal in PENDING state.
+ * Function that can be used to invalidate a proposal in PENDING or ACCEPTED
+ * state.
  * This is intended to facilitate the timelocking - where if a proposal hasn't
- * been confirmed, it gets deleted.
- * Fails if invoked on a CONFIRMED proposal.
+ * been confirmed, it is moved to the terminal INVALIDATED state. It is kept
+ * in state, along with who invalidated it and when, for auditing, and an
+ * event is fired so that the handler can clean up. Any tokens locked by the
+ * proposal are refunded to its creator.
+ * Fails if invoked on a CONFIRMED proposal, or before the timelock expires.
  */
 func (s *HashTimeLockContract) invalidateProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	var err error
@@ -205,33 +463,408 @@ func (s *HashTimeLockContract) invalidateProposal(stub shim.ChaincodeStubInterfa
 	if len(args) != 1 {
 		return shim.Error("Invalid arguments to invalidateProposal, expected proposalId")
 	}
-	/*
-	 * All sorts of validation logic about who can invalidate a proposal, maybe
-	 * check whether we have exceeded a minimum elapsed time or something?
-	 */
-	proposalBytes, err := stub.GetState(proposalPrefix + args[0])
+	proposal, ok, err := getStoredProposal(stub, args[0])
 	if err != nil {
-		return shim.Error("Error retreiving stored proposal from state")
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		return shim.Error("No such proposal.")
+	}
+	if !isOpen(proposal.Status) {
+		return shim.Error("Only pending proposals can be timed out.")
 	}
-	proposal := proposalEntry{}
-	err = json.Unmarshal(proposalBytes, &proposal)
+	config, err := getConfig(stub)
 	if err != nil {
-		return shim.Error("Error while parsing the proposal stored in state - " + err.Error())
+		return shim.Error(err.Error())
+	}
+	expired, err := hasExpired(stub, proposal, config)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !expired {
+		return shim.Error("The timelock on this proposal has not yet expired.")
+	}
+	//Only the creator, or a timeout service, can invalidate
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canInvalidate(caller, proposal) {
+		return shim.Error("Only the proposal creator or a timeout service can invalidate this proposal.")
+	}
+	for _, validator := range s.validators {
+		err = validator.OnInvalidate(stub, proposal, caller)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	//Mark the proposal as invalidated, refunding anything it locked
+	proposal.Status = InvalidatedStatus
+	err = refundEscrow(stub, proposal.Escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal.Invalidation, err = newTransitionRecord(stub, caller, "The timelock on this proposal expired.")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putStoredProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setProposalEvents(stub, newUnwindEvent(stub, events.Invalidation, proposal, proposal.Invalidation))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+/*
+ * Allows the handler to acknowledge a proposal in PENDING state, telling its
+ * creator that it has been seen and will be acted on, rather than leaving
+ * them to wait for the timelock. Moves it to ACCEPTED, where it can still be
+ * confirmed, invalidated or rejected. Proposals created with
+ * requireAcceptance can't be confirmed until they have been accepted.
+ */
+func (s *HashTimeLockContract) acceptProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var err error
+	//Validate the args, expect 1, the proposalId
+	if len(args) != 1 {
+		return shim.Error("Invalid arguments to acceptProposal, expected proposalId.")
+	}
+	proposal, ok, err := getStoredProposal(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		return shim.Error("No such proposal.")
 	}
 	if proposal.Status != PendingStatus {
-		return shim.Error("Only pending proposals can be timed out.")
+		return shim.Error("Only pending proposals can be accepted.")
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	//There's no point taking on a proposal which can no longer be confirmed.
+	//Proposals stored before expiries were recorded have none.
+	if proposal.Expiry != 0 {
+		expired, err := hasExpired(stub, proposal, config)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+		if expired {
+			return shim.Error("The timelock on this proposal has expired.")
+		}
+	}
+	//Only the organisation of the tagged handler can accept
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canConfirm(caller, proposal) {
+		return shim.Error("Only the organisation of the proposal handler can accept this proposal.")
+	}
+	controls, err := getControls(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = controls.checkHandler(proposal.Proposal.Handler)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	for _, validator := range s.validators {
+		err = validator.OnAccept(stub, proposal, caller)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
 	}
-	//Delete the proposal
-	err = stub.DelState(proposalPrefix + args[0])
+
+	proposal.Status = AcceptedStatus
+	proposal.Acceptance, err = newTransitionRecord(stub, caller, "")
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putStoredProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	acceptanceEvent := events.Event{Type: events.Acceptance, ProposalID: args[0], Handler: eventHandler(proposal), Expiry: proposal.Expiry}
+	err = setProposalEvents(stub, acceptanceEvent)
 	if err != nil {
-		return shim.Error("Error while deleting proposal from state.")
+		return shim.Error(err.Error())
 	}
 	return shim.Success(nil)
 }
 
+/*
+ * Allows the handler to decline a proposal in PENDING or ACCEPTED state, for
+ * instance because the other leg couldn't be set up, moving it to the
+ * terminal REJECTED state without waiting for the timelock to expire. Takes
+ * the proposalId and a reason, which are passed on in the event so that the
+ * other leg can be unwound. Any tokens locked by the proposal are refunded
+ * to its creator.
+ */
+func (s *HashTimeLockContract) rejectProposal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var err error
+	//Validate the args, expect 2, the proposalId and the reason
+	if len(args) != 2 {
+		return shim.Error("Invalid arguments to rejectProposal, expected proposalId, reason.")
+	}
+	proposal, ok, err := getStoredProposal(stub, args[0])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !ok {
+		return shim.Error("No such proposal.")
+	}
+	if !isOpen(proposal.Status) {
+		return shim.Error("Only pending proposals can be rejected.")
+	}
+	//Only the organisation of the tagged handler can reject
+	caller, err := getClientIdentity(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	config, err := getConfig(stub)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	if !config.AccessPolicy.canConfirm(caller, proposal) {
+		return shim.Error("Only the organisation of the proposal handler can reject this proposal.")
+	}
+	for _, validator := range s.validators {
+		err = validator.OnReject(stub, proposal, caller)
+		if err != nil {
+			return shim.Error(err.Error())
+		}
+	}
+
+	//Mark the proposal as rejected, refunding anything it locked
+	proposal.Status = RejectedStatus
+	err = refundEscrow(stub, proposal.Escrow)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	proposal.Rejection, err = newTransitionRecord(stub, caller, args[1])
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = putStoredProposal(stub, proposal)
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	err = setProposalEvents(stub, newUnwindEvent(stub, events.Rejection, proposal, proposal.Rejection))
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+	return shim.Success(nil)
+}
+
+//isOpen checks whether a proposal with the status can still be confirmed,
+//invalidated or rejected
+func isOpen(status string) bool {
+	return status == PendingStatus || status == AcceptedStatus
+}
+
+//newTransitionRecord captures the transaction id, timestamp and the MSP of
+//the creator for the current transaction, along with the reason given
+func newTransitionRecord(stub shim.ChaincodeStubInterface, caller clientIdentity, reason string) (*transitionRecord, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	return &transitionRecord{TxID: stub.GetTxID(), Timestamp: txTime, MSPID: caller.MSPID, Reason: reason}, nil
+}
+
+//isSameSubmission checks whether a resubmitted proposal is byte-identical to
+//the stored one, comparing the proposal, hash, hashing algorithm, minimum
+//pre-image length, whether it requires acceptance and the pre-image recipient
+func isSameSubmission(existing proposalEntry, resubmitted proposalEntry) bool {
+	existingAsBytes, err := json.Marshal(existing.Proposal)
+	if err != nil {
+		return false
+	}
+	resubmittedAsBytes, err := json.Marshal(resubmitted.Proposal)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(existingAsBytes, resubmittedAsBytes) &&
+		existing.Hash == resubmitted.Hash &&
+		existing.HashAlgorithm == resubmitted.HashAlgorithm &&
+		existing.MinPreImageLength == resubmitted.MinPreImageLength &&
+		existing.RequireAcceptance == resubmitted.RequireAcceptance &&
+		existing.PreImageRecipient == resubmitted.PreImageRecipient
+}
+
+//getConfig retrieves the contract configuration from state, falling back to
+//the defaults for anything which hasn't been configured
+func getConfig(stub shim.ChaincodeStubInterface) (contractConfig, error) {
+	config := contractConfig{DefaultTimelock: defaultTimelock, ClockSkewTolerance: defaultClockSkewTolerance, RouteSafetyDelta: defaultRouteSafetyDelta,
+		LinkSafetyMargin: defaultLinkSafetyMargin}
+	configAsBytes, err := stub.GetState(configKey)
+	if err != nil {
+		return config, fmt.Errorf("Error retreiving configuration from state - %s", err.Error())
+	}
+	if configAsBytes == nil {
+		return config, nil
+	}
+	err = json.Unmarshal(configAsBytes, &config)
+	if err != nil {
+		return config, fmt.Errorf("Error parsing configuration stored in state - %s", err.Error())
+	}
+	return config, nil
+}
+
+//getTxTime returns the transaction timestamp as unix seconds. Bear in mind
+//that this is set by the submitting client, not by the network.
+func getTxTime(stub shim.ChaincodeStubInterface) (int64, error) {
+	txTimestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("Error retreiving the transaction timestamp - %s", err.Error())
+	}
+	return txTimestamp.Seconds, nil
+}
+
+//resolveExpiry works out the expiry for a new proposal from the supplied
+//options, or the configured default timelock, which must fall after the
+//current transaction timestamp
+func resolveExpiry(stub shim.ChaincodeStubInterface, options createOptions, config contractConfig) (int64, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return 0, err
+	}
+	if options.Timelock != "" && options.Expiry != "" {
+		return 0, errors.New("Only one of timelock or expiry can be provided.")
+	}
+	var expiry int64
+	switch {
+	case options.Expiry != "":
+		expiryTime, err := time.Parse(time.RFC3339, options.Expiry)
+		if err != nil {
+			return 0, fmt.Errorf("Error parsing expiry, expected an RFC3339 timestamp - %s", err.Error())
+		}
+		expiry = expiryTime.Unix()
+	case options.Timelock != "":
+		timelock, err := time.ParseDuration(options.Timelock)
+		if err != nil {
+			return 0, fmt.Errorf("Error parsing timelock, expected a duration - %s", err.Error())
+		}
+		expiry = txTime + int64(timelock/time.Second)
+	default:
+		expiry = txTime + config.DefaultTimelock
+	}
+	if expiry <= txTime {
+		return 0, errors.New("The timelock must expire after the transaction timestamp.")
+	}
+	return expiry, nil
+}
+
+//indexProposal adds a proposal to the hash index. The hash of a proposal never
+//changes, so the index only needs writing when it is created.
+func indexProposal(stub shim.ChaincodeStubInterface, proposal proposalEntry) error {
+	indexKey, err := stub.CreateCompositeKey(hashIndex, []string{proposal.Hash, proposal.Proposal.ProposalID})
+	if err != nil {
+		return fmt.Errorf("Error building hash index key - %s", err.Error())
+	}
+	//The key holds everything, but Fabric won't store an empty value
+	err = stub.PutState(indexKey, []byte{0x00})
+	if err != nil {
+		return fmt.Errorf("Error writing hash index to state - %s", err.Error())
+	}
+	return nil
+}
+
+//migrateHashIndex indexes the stored proposals the first time the chaincode
+//is instantiated or upgraded with the hash index, and records that it has, so
+//later upgrades don't rewrite the whole index
+func migrateHashIndex(stub shim.ChaincodeStubInterface) error {
+	migrated, err := stub.GetState(hashIndexMigrationKey)
+	if err != nil {
+		return fmt.Errorf("Error reading migration state - %s", err.Error())
+	}
+	if migrated != nil {
+		return nil
+	}
+	err = indexProposals(stub)
+	if err != nil {
+		return err
+	}
+	err = stub.PutState(hashIndexMigrationKey, []byte{0x01})
+	if err != nil {
+		return fmt.Errorf("Error writing migration state - %s", err.Error())
+	}
+	return nil
+}
+
+//indexProposals adds every stored proposal to the hash index, so that those
+//created before the index was introduced can be found by hash
+func indexProposals(stub shim.ChaincodeStubInterface) error {
+	iterator, err := stub.GetStateByRange(proposalPrefix, proposalPrefix+string(utf8.MaxRune))
+	if err != nil {
+		return fmt.Errorf("Error while querying proposals from state - %s", err.Error())
+	}
+	defer iterator.Close()
+	for iterator.HasNext() {
+		kv, err := iterator.Next()
+		if err != nil {
+			return fmt.Errorf("Error while querying proposals from state - %s", err.Error())
+		}
+		proposal := proposalEntry{}
+		err = json.Unmarshal(kv.Value, &proposal)
+		if err != nil {
+			return fmt.Errorf("Error while parsing the proposal stored in state - %s", err.Error())
+		}
+		//Hashes stored before they were normalised may be in uppercase
+		proposal.Hash = strings.ToLower(proposal.Hash)
+		err = indexProposal(stub, proposal)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//isHashUsed checks whether any proposal has been created with the hash
+func isHashUsed(stub shim.ChaincodeStubInterface, hash string) (bool, error) {
+	iterator, err := stub.GetStateByPartialCompositeKey(hashIndex, []string{hash})
+	if err != nil {
+		return false, fmt.Errorf("Error while querying the hash index - %s", err.Error())
+	}
+	defer iterator.Close()
+	return iterator.HasNext(), nil
+}
+
+//resolveMinPreImageLength works out the minimum pre-image length for a new
+//proposal. The options can raise the configured minimum, but not lower it.
+func resolveMinPreImageLength(options createOptions, config contractConfig) (int, error) {
+	if options.MinPreImageLength < 0 {
+		return 0, codedError{ErrInvalidPreImagePolicy, "The minPreImageLength cannot be negative."}
+	}
+	if options.MinPreImageLength < config.MinPreImageLength {
+		if options.MinPreImageLength != 0 {
+			return 0, codedError{ErrInvalidPreImagePolicy, fmt.Sprintf("The minPreImageLength cannot be lower than the configured %d bytes.", config.MinPreImageLength)}
+		}
+		return config.MinPreImageLength, nil
+	}
+	return options.MinPreImageLength, nil
+}
+
+//hasExpired checks whether the timelock on a proposal has passed at the time
+//of this transaction. The configured clock skew tolerance is granted in
+//favour of the confirmer, so invalidation must wait until it has also passed.
+func hasExpired(stub shim.ChaincodeStubInterface, proposal proposalEntry, config contractConfig) (bool, error) {
+	txTime, err := getTxTime(stub)
+	if err != nil {
+		return false, err
+	}
+	return txTime > proposal.Expiry+config.ClockSkewTolerance, nil
+}
+
 func main() {
 	// Create a new Smart Contract
-	err := shim.Start(new(HashTimeLockContract))
+	err := shim.Start(newHashTimeLockContract())
 	if err != nil {
 		fmt.Printf("Error creating new Smart Contract: %s", err)
 	}
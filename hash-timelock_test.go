@@ -5,15 +5,16 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
 )
 
 func TestCrossChannelConfirmation(t *testing.T) {
-	channelOne := shim.NewMockStub("channelOne", new(HashTimeLockContract))
+	channelOne := newTestStub("channelOne", new(HashTimeLockContract))
 	if channelOne == nil {
 		t.Fatalf("channelOne creation failed")
 	}
-	channelTwo := shim.NewMockStub("channelTwo", new(HashTimeLockContract))
+	channelTwo := newTestStub("channelTwo", new(HashTimeLockContract))
 	if channelTwo == nil {
 		t.Fatalf("channelTwo creation failed")
 	}
@@ -25,6 +26,7 @@ func TestCrossChannelConfirmation(t *testing.T) {
 	hashAlg := "SHA512"
 
 	//Create our proposal in channel one.
+	channelOne.Creator = newTestIdentity(t, "Alice", nil)
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
 		"\"proposalHandler\": \"Bob\"" +
@@ -36,29 +38,39 @@ func TestCrossChannelConfirmation(t *testing.T) {
 		t.Errorf("Error - %s", res.Message)
 	}
 	//Have the channel one 'handler' catch the proposal event
-	channelOneProposalEvent := <-channelOne.ChaincodeEventsChannel
+	channelOneProposalEvent := nextTestEvent(t, channelOne)
 	if channelOneProposalEvent == nil {
-		t.Error("No proposal handler event fired!")
+		t.Fatal("No proposal event fired!")
 	}
-	if channelOneProposalEvent.EventName != "Bob"+ProposalCreatedHandlerEvent {
-		t.Errorf("Create proposal fired event with name %s, but expected %s.", channelOneProposalEvent.EventName, "Bob"+ProposalCreatedHandlerEvent)
-	}
-	channelOneProposalCreatedEvent := ProposalCreatedEventObject{}
-	err := json.Unmarshal(channelOneProposalEvent.Payload, &channelOneProposalCreatedEvent)
+	channelOneEvents, err := events.Decode(channelOneProposalEvent.Payload)
 	if err != nil {
-		t.Error("Error while unmarshalling event fired when creating proposal in channel one.")
+		t.Error("Error while decoding event fired when creating proposal in channel one.")
+	}
+	channelOneHandlerEvents := channelOneEvents.ForHandler("Bob")
+	if len(channelOneHandlerEvents) == 0 || channelOneHandlerEvents[0].Type != events.HandlerNotification {
+		t.Fatalf("Create proposal fired events %v, but expected a %s for Bob.", channelOneEvents.Events, events.HandlerNotification)
 	}
-	//Clear the events channel
-	_ = <-channelOne.ChaincodeEventsChannel
+	channelOneProposalCreatedEvent := channelOneHandlerEvents[0]
 
 	//Handler then creates the proposal in channel two, using the values from the event
-	//In reality, would probably retrieve the proposal and hash from channel one, but there are no
-	//functions for that in this PoC...
+	//to retrieve the proposal and hash from channel one
+	args = [][]byte{[]byte("getProposal"), []byte(channelOneProposalCreatedEvent.ProposalID)}
+	res = channelOne.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Get Proposal channel one returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	channelOneProposal := proposalEntry{}
+	err = json.Unmarshal(res.Payload, &channelOneProposal)
+	if err != nil {
+		t.Error("Error while unmarshalling proposal retrieved from channel one.")
+	}
 	testProposal = "{" +
-		"\"proposalId\": \"" + channelOneProposalCreatedEvent.ProposalID + "\"," +
+		"\"proposalId\": \"" + channelOneProposal.Proposal.ProposalID + "\"," +
 		"\"proposalHandler\": \"Charlie\"" +
 		"}"
-	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte(hashAlg)}
+	channelTwo.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(channelOneProposal.Hash), []byte(channelOneProposal.HashAlgorithm)}
 	res = channelTwo.MockInvoke("txid1", args)
 	if res.Status != 200 {
 		t.Errorf("Create Proposal channel two returned non-OK status, got: %d, want: %d.", res.Status, 200)
@@ -66,23 +78,21 @@ func TestCrossChannelConfirmation(t *testing.T) {
 	}
 
 	//Channel two 'handler' catches this proposal - then supplies the pre-image to confirm
-	channelTwoProposalEvent := <-channelTwo.ChaincodeEventsChannel
+	channelTwoProposalEvent := nextTestEvent(t, channelTwo)
 	if channelTwoProposalEvent == nil {
-		t.Error("No proposal handler event fired!")
-	}
-	if channelTwoProposalEvent.EventName != "Charlie"+ProposalCreatedHandlerEvent {
-		t.Errorf("Create proposal fired event with name %s, but expected %s.", channelTwoProposalEvent.EventName, "Charlie"+ProposalCreatedHandlerEvent)
+		t.Fatal("No proposal event fired!")
 	}
-	channelTwoProposalCreatedEvent := ProposalCreatedEventObject{}
-	err = json.Unmarshal(channelTwoProposalEvent.Payload, &channelTwoProposalCreatedEvent)
+	channelTwoEvents, err := events.Decode(channelTwoProposalEvent.Payload)
 	if err != nil {
-		t.Error("Error while unmarshalling event fired when creating proposal in channel two.")
+		t.Error("Error while decoding event fired when creating proposal in channel two.")
+	}
+	if len(channelTwoEvents.ForHandler("Charlie")) == 0 {
+		t.Errorf("Create proposal fired events %v, but expected some for Charlie.", channelTwoEvents.Events)
 	}
-	//Clear the events channel
-	_ = <-channelTwo.ChaincodeEventsChannel
 
 	//Since we recieved a proposal that we know about (presumably, there needs
 	//to be some logic to establish this), we can supply the pre-image to confirm
+	channelTwo.Creator = newTestIdentity(t, "Charlie", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
 	res = channelTwo.MockInvoke("txid2", args)
 	if res.Status != 200 {
@@ -90,19 +100,21 @@ func TestCrossChannelConfirmation(t *testing.T) {
 		t.Errorf("Error - %s", res.Message)
 	}
 	//Channel one 'handler' catches the confirmation event, then replays it into channel one
-	channelTwoProposalConfirmed := <-channelTwo.ChaincodeEventsChannel
-	if channelOneProposalEvent == nil {
-		t.Error("No proposal confirmation event fired!")
+	channelTwoProposalConfirmed := nextTestEvent(t, channelTwo)
+	if channelTwoProposalConfirmed == nil {
+		t.Fatal("No proposal confirmation event fired!")
 	}
-	if channelTwoProposalConfirmed.EventName != ProposalConfirmedHandlerEvent {
-		t.Errorf("Confirm proposal fired event with name %s, but expected %s.", channelTwoProposalConfirmed.EventName, ProposalConfirmedHandlerEvent)
-	}
-	channelTwoProposalConfirmEvent := ProposalConfirmedEventObject{}
-	err = json.Unmarshal(channelTwoProposalConfirmed.Payload, &channelTwoProposalConfirmEvent)
+	channelTwoEvents, err = events.Decode(channelTwoProposalConfirmed.Payload)
 	if err != nil {
-		t.Error("Error while unmarshalling event fired when confirming proposal in channel two.")
+		t.Error("Error while decoding event fired when confirming proposal in channel two.")
+	}
+	channelTwoConfirmations := channelTwoEvents.OfType(events.Confirmation)
+	if len(channelTwoConfirmations) != 1 {
+		t.Fatalf("Confirm proposal fired events %v, but expected a single %s.", channelTwoEvents.Events, events.Confirmation)
 	}
+	channelTwoProposalConfirmEvent := channelTwoConfirmations[0]
 	//Use the hash in that event to confirm the proposal in channel one
+	channelOne.Creator = newTestIdentity(t, "Bob", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(channelTwoProposalConfirmEvent.PreImage)}
 	res = channelOne.MockInvoke("txid2", args)
 	if res.Status != 200 {
@@ -113,7 +125,8 @@ func TestCrossChannelConfirmation(t *testing.T) {
 	//the confirmation provided by C in channel two.
 	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"CONFIRMED\"," +
 		"\"hash\":\"5a32f0967623012cdd4c29257f808f3f209184e992c39dc6d931f89831e7b1eb9379f9e3a20da09eb06d0ca53bd9c0845dda91baed17a713c0cac8a24259c0b9\"," +
-		"\"hashAlgorithm\":\"SHA512\"}"
+		"\"hashAlgorithm\":\"SHA512\",\"expiry\":1500086400," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"},\"preImage\":\"test_hash\",\"preImageEncoding\":\"raw\"}"
 	proposal, err := channelOne.GetState(proposalPrefix + "prop1234")
 	if err != nil {
 		t.Error("Error getting proposal by id from mock stub")
@@ -124,22 +137,24 @@ func TestCrossChannelConfirmation(t *testing.T) {
 }
 
 func TestCreateProposalSuccess(t *testing.T) {
-	stub := shim.NewMockStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
 	if stub == nil {
 		t.Fatalf("MockStub creation failed")
 	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
 		"\"proposalHandler\": \"Bob\"" +
 		"}"
-	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte("hash"), []byte("SHA512")}
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
 	res := stub.MockInvoke("txid1", args)
 	if res.Status != 200 {
 		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
 		t.Errorf("Error - %s", res.Message)
 	}
 	//Check that the object was created
-	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"hash\",\"hashAlgorithm\":\"SHA512\"}"
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"" + testHashes["SHA512"] + "\",\"hashAlgorithm\":\"SHA512\",\"expiry\":1500086400," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"}}"
 	proposal, err := stub.GetState(proposalPrefix + "prop1234")
 	if err != nil {
 		t.Error("Error getting proposal by id from mock stub")
@@ -147,27 +162,22 @@ func TestCreateProposalSuccess(t *testing.T) {
 	if string(proposal) != expectedRes {
 		t.Errorf("Create proposal created %s, but expected: %s.", string(proposal), expectedRes)
 	}
-	//Check if events were fired
-	expectedEvent := "{\"proposalId\":\"prop1234\"}"
-	proposalHandlerEvent := <-stub.ChaincodeEventsChannel
-	if proposalHandlerEvent == nil {
-		t.Error("No proposal handler event fired!")
-	}
-	if proposalHandlerEvent.EventName != "Bob"+ProposalCreatedHandlerEvent {
-		t.Errorf("Create proposal fired event with name %s, but expected %s.", proposalHandlerEvent.EventName, "Bob"+ProposalCreatedHandlerEvent)
+	//Check that both the handler and timeout events were fired, in a single event
+	expectedEvent := "{\"events\":[" +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"expiry\":1500086400}," +
+		"{\"type\":\"TIMEOUT_REGISTRATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"expiry\":1500086400}]}"
+	proposalEvent := nextTestEvent(t, stub)
+	if proposalEvent == nil {
+		t.Fatal("No proposal event fired!")
 	}
-	if string(proposalHandlerEvent.Payload) != expectedEvent {
-		t.Errorf("Create proposal fired event with payload %s, but expected %s.", string(proposalHandlerEvent.Payload), expectedEvent)
+	if proposalEvent.EventName != events.Name {
+		t.Errorf("Create proposal fired event with name %s, but expected %s.", proposalEvent.EventName, events.Name)
 	}
-	proposalTimeoutEvent := <-stub.ChaincodeEventsChannel
-	if proposalTimeoutEvent == nil {
-		t.Error("No proposal timeout event fired!")
+	if string(proposalEvent.Payload) != expectedEvent {
+		t.Errorf("Create proposal fired event with payload %s, but expected %s.", string(proposalEvent.Payload), expectedEvent)
 	}
-	if proposalTimeoutEvent.EventName != ProposalCreateTimeoutEvent {
-		t.Errorf("Create proposal fired timeout event with name %s, but expected %s.", proposalTimeoutEvent.EventName, ProposalCreateTimeoutEvent)
-	}
-	if string(proposalTimeoutEvent.Payload) != expectedEvent {
-		t.Errorf("Create proposal fired timeout event with payload %s, but expected %s.", string(proposalTimeoutEvent.Payload), expectedEvent)
+	if len(stub.ChaincodeEventsChannel) != 0 {
+		t.Error("Create proposal fired more than one event.")
 	}
 }
 
@@ -186,7 +196,7 @@ func TestCreateProposalInvalidArgs(t *testing.T) {
 		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
 	}
 	//Check that the error message is appropriate
-	expectedMessage := "Invalid arguments to createProposal, expected proposal, hash, hashingAlg."
+	expectedMessage := "Invalid arguments to createProposal, expected proposal, hash, hashingAlg and optionally options."
 	if res.Message != expectedMessage {
 		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
 	}
@@ -200,7 +210,7 @@ func TestCreateProposalInvalidPropId(t *testing.T) {
 	testProposal := "{" +
 		"\"proposalHandler\": \"Bob\"" +
 		"}"
-	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte("hash"), []byte("SHA512")}
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
 	res := stub.MockInvoke("txid1", args)
 	if res.Status != 500 {
 		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
@@ -220,7 +230,7 @@ func TestCreateProposalInvalidHandler(t *testing.T) {
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"" +
 		"}"
-	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte("hash"), []byte("SHA512")}
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
 	res := stub.MockInvoke("txid1", args)
 	if res.Status != 500 {
 		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
@@ -246,17 +256,18 @@ func TestCreateProposalInvalidHashAlg(t *testing.T) {
 		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
 	}
 	//Check that the error message is appropriate
-	expectedMessage := "Only these hashing algorithms are supported: " + strings.Join(validHashingAlgorithms, ", ")
+	expectedMessage := ErrUnsupportedHashAlgorithm + ": Only these hashing algorithms are supported: " + strings.Join(supportedHashAlgorithms(), ", ")
 	if res.Message != expectedMessage {
 		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
 	}
 }
 
 func TestConfirmProposalSuccess(t *testing.T) {
-	stub := shim.NewMockStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
 	if stub == nil {
 		t.Fatalf("MockStub creation failed")
 	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
 	//Create a proposal as a pre-req
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
@@ -271,9 +282,10 @@ func TestConfirmProposalSuccess(t *testing.T) {
 		t.Errorf("Error - %s", res.Message)
 	}
 	//Clear the event channel
-	_, _ = <-stub.ChaincodeEventsChannel, <-stub.ChaincodeEventsChannel
+	nextTestEvent(t, stub)
 
 	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
 	res = stub.MockInvoke("txid2", args)
 	if res.Status != 200 {
@@ -283,7 +295,8 @@ func TestConfirmProposalSuccess(t *testing.T) {
 	//Check that the object was updated
 	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"CONFIRMED\"," +
 		"\"hash\":\"5a32f0967623012cdd4c29257f808f3f209184e992c39dc6d931f89831e7b1eb9379f9e3a20da09eb06d0ca53bd9c0845dda91baed17a713c0cac8a24259c0b9\"," +
-		"\"hashAlgorithm\":\"SHA512\"}"
+		"\"hashAlgorithm\":\"SHA512\",\"expiry\":1500086400," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"},\"preImage\":\"test_hash\",\"preImageEncoding\":\"raw\"}"
 	proposal, err := stub.GetState(proposalPrefix + "prop1234")
 	if err != nil {
 		t.Error("Error getting proposal by id from mock stub")
@@ -292,16 +305,16 @@ func TestConfirmProposalSuccess(t *testing.T) {
 		t.Errorf("Confirm proposal created %s, but expected: %s.", string(proposal), expectedRes)
 	}
 	//Check if events were fired
-	expectedEvent := "{\"proposalId\":\"prop1234\",\"preImage\":\"test_hash\"}"
-	proposalConfirmationEvent := <-stub.ChaincodeEventsChannel
+	expectedEvent := "{\"events\":[{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"preImage\":\"test_hash\",\"preImageEncoding\":\"raw\"}]}"
+	proposalConfirmationEvent := nextTestEvent(t, stub)
 	if proposalConfirmationEvent == nil {
-		t.Error("No proposal handler event fired!")
+		t.Fatal("No proposal confirmation event fired!")
 	}
-	if proposalConfirmationEvent.EventName != ProposalConfirmedHandlerEvent {
-		t.Errorf("Create proposal fired event with name %s, but expected %s.", proposalConfirmationEvent.EventName, ProposalConfirmedHandlerEvent)
+	if proposalConfirmationEvent.EventName != events.Name {
+		t.Errorf("Confirm proposal fired event with name %s, but expected %s.", proposalConfirmationEvent.EventName, events.Name)
 	}
 	if string(proposalConfirmationEvent.Payload) != expectedEvent {
-		t.Errorf("Create proposal fired event with payload %s, but expected %s.", string(proposalConfirmationEvent.Payload), expectedEvent)
+		t.Errorf("Confirm proposal fired event with payload %s, but expected %s.", string(proposalConfirmationEvent.Payload), expectedEvent)
 	}
 }
 
@@ -316,7 +329,7 @@ func TestConfirmProposalInvalidArgs(t *testing.T) {
 		t.Errorf("Confirm Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
 	}
 	//Check that the error message is appropriate
-	expectedMessage := "Invalid arguments to confirmProposal, expected proposalId, pre-image."
+	expectedMessage := "Invalid arguments to confirmProposal, expected proposalId, pre-image and optionally encoding."
 	if res.Message != expectedMessage {
 		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
 	}
@@ -335,17 +348,18 @@ func TestConfirmProposalNoSuchProposal(t *testing.T) {
 		t.Errorf("Confirm Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
 	}
 	//Check that the error message is appropriate
-	expectedMessage := "No such proposal. It may have expired and been invalidated."
+	expectedMessage := "No such proposal."
 	if res.Message != expectedMessage {
 		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
 	}
 }
 
 func TestConfirmProposalWithSHA256(t *testing.T) {
-	stub := shim.NewMockStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
 	if stub == nil {
 		t.Fatalf("MockStub creation failed")
 	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
 	//Create a proposal as a pre-req
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
@@ -361,6 +375,7 @@ func TestConfirmProposalWithSHA256(t *testing.T) {
 	}
 
 	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
 	res = stub.MockInvoke("txid2", args)
 	if res.Status != 200 {
@@ -370,17 +385,19 @@ func TestConfirmProposalWithSHA256(t *testing.T) {
 }
 
 func TestConfirmProposalInvalidHash(t *testing.T) {
-	stub := shim.NewMockStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
 	if stub == nil {
 		t.Fatalf("MockStub creation failed")
 	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
 	//Create a proposal as a pre-req
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
 		"\"proposalHandler\": \"Bob\"" +
 		"}"
 	preImage := "test_hash"
-	hash := "not_hash"
+	//A well formed hash, which doesn't match the pre-image
+	hash := strings.Repeat("00", 64)
 	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA512")}
 	res := stub.MockInvoke("txid1", args)
 	if res.Status != 200 {
@@ -389,6 +406,7 @@ func TestConfirmProposalInvalidHash(t *testing.T) {
 	}
 
 	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
 	res = stub.MockInvoke("txid2", args)
 	if res.Status != 500 {
@@ -416,10 +434,11 @@ func TestConfirmProposalInvalidHash(t *testing.T) {
 }
 
 func TestConfirmProposalUsingUppercaseHashString(t *testing.T) {
-	stub := shim.NewMockStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
 	if stub == nil {
 		t.Fatalf("MockStub creation failed")
 	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
 	//Create a proposal as a pre-req
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
@@ -435,6 +454,7 @@ func TestConfirmProposalUsingUppercaseHashString(t *testing.T) {
 	}
 
 	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
 	res = stub.MockInvoke("txid2", args)
 	if res.Status != 200 {
@@ -444,10 +464,11 @@ func TestConfirmProposalUsingUppercaseHashString(t *testing.T) {
 }
 
 func TestConfirmProposalWithSHA384(t *testing.T) {
-	stub := shim.NewMockStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
 	if stub == nil {
 		t.Fatalf("MockStub creation failed")
 	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
 	//Create a proposal as a pre-req
 	testProposal := "{" +
 		"\"proposalId\": \"prop1234\"," +
@@ -463,10 +484,798 @@ func TestConfirmProposalWithSHA384(t *testing.T) {
 	}
 
 	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestConfirmProposalWithKeccak256(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	if stub == nil {
+		t.Fatalf("MockStub creation failed")
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	//Create a proposal as a pre-req
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	preImage := "test_hash"
+	hash := "74ee1301970ba3b72d3abd85d5f4f288c277025d2fdf2bf7f7a3397a303242d7"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("KECCAK256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+
+	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestConfirmProposalWithHash160(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	if stub == nil {
+		t.Fatalf("MockStub creation failed")
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	//Create a proposal as a pre-req
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	preImage := "test_hash"
+	hash := "1c15f3dfe4a523431979a8fbf0246bb2e89ebd77"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("HASH160")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+
+	//Run the confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestCreateProposalNormalisesHash(t *testing.T) {
+	submissions := []struct {
+		hash    string
+		options string
+	}{
+		{"0x6B70A820EB978882FA49B199C853A5676E5E1A4744371BE5AFFD4B3AF1F5DDE6", "{}"},
+		{"a3CoIOuXiIL6SbGZyFOlZ25eGkdENxvlr/1LOvH13eY=", "{\"hashEncoding\":\"base64\"}"},
+	}
+	for _, submission := range submissions {
+		stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+		stub.Creator = newTestIdentity(t, "Alice", nil)
+		testProposal := "{" +
+			"\"proposalId\": \"prop1234\"," +
+			"\"proposalHandler\": \"Bob\"" +
+			"}"
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(submission.hash), []byte("SHA256"), []byte(submission.options)}
+		res := stub.MockInvoke("txid1", args)
+		if res.Status != 200 {
+			t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+		}
+		proposal := proposalEntry{}
+		proposalAsBytes, _ := stub.GetState(proposalPrefix + "prop1234")
+		json.Unmarshal(proposalAsBytes, &proposal)
+		if proposal.Hash != testHashes["SHA256"] {
+			t.Errorf("Create Proposal stored hash %s for %s, but expected %s.", proposal.Hash, submission.hash, testHashes["SHA256"])
+		}
+	}
+}
+
+func TestCreateProposalInvalidHashEncoding(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte("not_hex"), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 || !strings.HasPrefix(res.Message, ErrInvalidHashEncoding+": Error decoding provided hash - ") {
+		t.Errorf("Create Proposal accepted a hash which isn't hexadecimal, got: %d %s.", res.Status, res.Message)
+	}
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"hashEncoding\":\"raw\"}")}
+	res = stub.MockInvoke("txid2", args)
+	expectedMessage := ErrInvalidHashEncoding + ": Error decoding provided hash - Hashes can only be encoded as hex or base64."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestConfirmProposalWithEncodedPreImage(t *testing.T) {
+	preImages := []struct {
+		preImage string
+		encoding string
+	}{
+		{"746573745f68617368", "hex"},
+		{"0x746573745F68617368", "hex"},
+		{"dGVzdF9oYXNo", "base64"},
+	}
+	for _, preImage := range preImages {
+		stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+		createTestProposal(t, stub)
+		//Clear the event channel
+		nextTestEvent(t, stub)
+
+		stub.Creator = newTestIdentity(t, "Bob", nil)
+		args := [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage.preImage), []byte(preImage.encoding)}
+		res := stub.MockInvoke("txid2", args)
+		if res.Status != 200 {
+			t.Errorf("Confirm Proposal returned non-OK status for %s, got: %d, want: %d.", preImage.preImage, res.Status, 200)
+			t.Errorf("Error - %s", res.Message)
+			continue
+		}
+		//The event carries the pre-image as supplied, so it can be replayed
+		expectedEvent := "{\"events\":[{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"," +
+			"\"preImage\":\"" + preImage.preImage + "\",\"preImageEncoding\":\"" + preImage.encoding + "\"}]}"
+		confirmationEvent := nextTestEvent(t, stub)
+		if string(confirmationEvent.Payload) != expectedEvent {
+			t.Errorf("Confirm proposal fired event with payload %s, but expected %s.", string(confirmationEvent.Payload), expectedEvent)
+		}
+	}
+}
+
+func TestConfirmProposalWithBinaryPreImage(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	hash := "b3d510ef04275ca8e698e5b3cbb0ece3949ef9252f0cdc839e9ee347409a2209"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	nextTestEvent(t, stub)
+
+	//A raw pre-image which isn't valid UTF-8 is reported in base64
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte{0xff, 0xfe}}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	expectedEvent := "{\"events\":[{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"," +
+		"\"preImage\":\"//4=\",\"preImageEncoding\":\"base64\"}]}"
+	confirmationEvent := nextTestEvent(t, stub)
+	if string(confirmationEvent.Payload) != expectedEvent {
+		t.Errorf("Confirm proposal fired event with payload %s, but expected %s.", string(confirmationEvent.Payload), expectedEvent)
+	}
+}
+
+func TestConfirmProposalInvalidPreImageEncoding(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash"), []byte("base32")}
+	res := stub.MockInvoke("txid2", args)
+	expectedMessage := ErrInvalidPreImageEncoding + ": Error decoding provided pre-image - Pre-images can only be encoded as raw, hex or base64."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestCreateProposalInvalidHashLength(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA512")}
+	res := stub.MockInvoke("txid1", args)
+	expectedMessage := ErrInvalidHashLength + ": SHA512 hashes are 64 bytes, but 32 bytes were provided."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	proposalAsBytes, _ := stub.GetState(proposalPrefix + "prop1234")
+	if proposalAsBytes != nil {
+		t.Error("Create Proposal stored a proposal with a hash of the wrong length.")
+	}
+}
+
+func TestCreateProposalMinPreImageLength(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"minPreImageLength\":4}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	//The options can raise the configured minimum, but not lower it
+	policies := []struct {
+		proposalID      string
+		options         string
+		expectedMessage string
+		expectedLength  int
+	}{
+		{"default", "{}", "", 4},
+		{"raised", "{\"minPreImageLength\":32}", "", 32},
+		{"lowered", "{\"minPreImageLength\":2}", ErrInvalidPreImagePolicy + ": The minPreImageLength cannot be lower than the configured 4 bytes.", 0},
+		{"negative", "{\"minPreImageLength\":-1}", ErrInvalidPreImagePolicy + ": The minPreImageLength cannot be negative.", 0},
+	}
+	for _, policy := range policies {
+		testProposal := "{" +
+			"\"proposalId\": \"" + policy.proposalID + "\"," +
+			"\"proposalHandler\": \"Bob\"" +
+			"}"
+		args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte(policy.options)}
+		res = stub.MockInvoke("txid1", args)
+		if res.Message != policy.expectedMessage {
+			t.Errorf("Expected Error: %s, got: %s", policy.expectedMessage, res.Message)
+		}
+		if policy.expectedLength == 0 {
+			continue
+		}
+		proposal := proposalEntry{}
+		proposalAsBytes, _ := stub.GetState(proposalPrefix + policy.proposalID)
+		json.Unmarshal(proposalAsBytes, &proposal)
+		if proposal.MinPreImageLength != policy.expectedLength {
+			t.Errorf("Create Proposal recorded a minimum pre-image length of %d, but expected %d.", proposal.MinPreImageLength, policy.expectedLength)
+		}
+	}
+
+	//The recorded minimum is enforced on confirmation
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("confirmProposal"), []byte("raised"), []byte("test_hash")}
+	res = stub.MockInvoke("txid2", args)
+	expectedMessage := ErrPreImageTooShort + ": The pre-image must be at least 32 bytes."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	args = [][]byte{[]byte("confirmProposal"), []byte("default"), []byte("test_hash")}
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestCreateProposalWithTimelock(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"timelock\":\"90m\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposalBytes, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	proposal := proposalEntry{}
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		t.Error("Error parsing proposal bytes into the proposal object")
+	}
+	if proposal.Expiry != testTime+90*60 {
+		t.Errorf("Create proposal set expiry %d, but expected %d.", proposal.Expiry, testTime+90*60)
+	}
+}
+
+func TestCreateProposalWithExpiry(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	//An hour after testTime
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"expiry\":\"2017-07-14T03:40:00Z\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposalBytes, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	proposal := proposalEntry{}
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		t.Error("Error parsing proposal bytes into the proposal object")
+	}
+	if proposal.Expiry != testTime+60*60 {
+		t.Errorf("Create proposal set expiry %d, but expected %d.", proposal.Expiry, testTime+60*60)
+	}
+}
+
+func TestCreateProposalExpiryInPast(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"expiry\":\"2017-07-14T01:40:00Z\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 {
+		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "The timelock must expire after the transaction timestamp."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestCreateProposalUsesConfiguredTimelock(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"defaultTimelock\":600}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512")}
+	res = stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposalBytes, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	proposal := proposalEntry{}
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		t.Error("Error parsing proposal bytes into the proposal object")
+	}
+	if proposal.Expiry != testTime+600 {
+		t.Errorf("Create proposal set expiry %d, but expected %d.", proposal.Expiry, testTime+600)
+	}
+}
+
+func TestConfirmProposalAfterExpiry(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	preImage := "test_hash"
+	hash := "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA256"), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//Past the expiry and the default clock skew tolerance
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	stub.Creator = newTestIdentity(t, "Bob", nil)
 	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
 	res = stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Confirm Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "The timelock on this proposal has expired."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestConfirmProposalWithinClockSkewTolerance(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	preImage := "test_hash"
+	hash := "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA256"), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestInvalidateProposalBeforeExpiry(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"timelock\":\"1h\"}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//Past the expiry, but not the clock skew tolerance
+	stub.TxTime = testTime + 60*60 + 1
+	args = [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Invalidate Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "The timelock on this proposal has not yet expired."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestInvalidateProposalAfterExpiry(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	res := stub.MockInit("txid0", [][]byte{[]byte("init"), []byte("{\"clockSkewTolerance\":0}")})
+	if res.Status != 200 {
+		t.Errorf("Init returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	preImage := "test_hash"
+	hash := "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA256"), []byte("{\"timelock\":\"1h\"}")}
+	res = stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.TxTime = testTime + 60*60 + 1
+	args = [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Invalidate Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//The proposal is kept, with a record of the invalidation
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"INVALIDATED\"," +
+		"\"hash\":\"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500003600," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"}," +
+		"\"invalidation\":{\"txId\":\"txid2\",\"timestamp\":1500003601,\"mspId\":\"Alice\",\"reason\":\"The timelock on this proposal expired.\"}}"
+	proposal, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if string(proposal) != expectedRes {
+		t.Errorf("Invalidate proposal stored %s, but expected: %s.", string(proposal), expectedRes)
+	}
+	//It can no longer be confirmed, or invalidated again
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = stub.MockInvoke("txid3", args)
+	expectedMessage := "The proposal has expired and been invalidated."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	args = [][]byte{[]byte("invalidateProposal"), []byte("prop1234")}
+	res = stub.MockInvoke("txid4", args)
+	expectedMessage = "Only pending proposals can be timed out."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestCreateProposalDuplicateId(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	preImage := "test_hash"
+	hash := "6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(hash), []byte("SHA256")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte(preImage)}
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//Resubmitting with a new hash must not reset the confirmed proposal
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256")}
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 500 {
+		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "A proposal with this proposalId already exists."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	proposalBytes, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	proposal := proposalEntry{}
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		t.Error("Error parsing proposal bytes into the proposal object")
+	}
+	if proposal.Status != ConfirmStatus || proposal.Hash != hash {
+		t.Errorf("Duplicate proposal overwrote the stored proposal: %s", string(proposalBytes))
+	}
+}
+
+func TestCreateProposalRetry(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{" +
+		"\"proposalId\": \"prop1234\"," +
+		"\"proposalHandler\": \"Bob\"" +
+		"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA512"]), []byte("SHA512"), []byte("{\"retry\":true}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//An identical retry later on succeeds, and leaves the expiry alone
+	stub.TxTime = testTime + 60
+	res = stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Create Proposal retry returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	proposalBytes, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	proposal := proposalEntry{}
+	err = json.Unmarshal(proposalBytes, &proposal)
+	if err != nil {
+		t.Error("Error parsing proposal bytes into the proposal object")
+	}
+	if proposal.Expiry != testTime+defaultTimelock {
+		t.Errorf("Retried proposal has expiry %d, but expected %d.", proposal.Expiry, testTime+defaultTimelock)
+	}
+	//A retry which differs from what is stored is rejected
+	args = [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA384"]), []byte("SHA384"), []byte("{\"retry\":true}")}
+	res = stub.MockInvoke("txid3", args)
+	if res.Status != 500 {
+		t.Errorf("Create Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "A different proposal with this proposalId already exists."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestRejectProposalSuccess(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.ChannelID = "channelOne"
+	createTestProposal(t, stub)
+	//Clear the event channel
+	nextTestEvent(t, stub)
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("No route to Charlie")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Reject Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	//The proposal is kept, with a record of the rejection
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"REJECTED\"," +
+		"\"hash\":\"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500003600," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"}," +
+		"\"rejection\":{\"txId\":\"txid2\",\"timestamp\":1500000000,\"mspId\":\"Bob\",\"reason\":\"No route to Charlie\"}}"
+	proposal, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if string(proposal) != expectedRes {
+		t.Errorf("Reject proposal stored %s, but expected: %s.", string(proposal), expectedRes)
+	}
+	expectedEvent := "{\"events\":[{\"type\":\"REJECTION\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"," +
+		"\"hash\":\"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6\",\"reason\":\"No route to Charlie\"," +
+		"\"channel\":\"channelOne\",\"timestamp\":1500000000}]}"
+	proposalRejectionEvent := nextTestEvent(t, stub)
+	if proposalRejectionEvent == nil {
+		t.Fatal("No proposal rejection event fired!")
+	}
+	if string(proposalRejectionEvent.Payload) != expectedEvent {
+		t.Errorf("Reject proposal fired event with payload %s, but expected %s.", string(proposalRejectionEvent.Payload), expectedEvent)
+	}
+	//It can no longer be confirmed, or rejected again
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")}
+	res = stub.MockInvoke("txid3", args)
+	expectedMessage := "The proposal has been rejected by the handler."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	args = [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("Again")}
+	res = stub.MockInvoke("txid4", args)
+	expectedMessage = "Only pending proposals can be rejected."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestRejectProposalNotHandler(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	args := [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("Changed my mind")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 500 {
+		t.Errorf("Reject Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "Only the organisation of the proposal handler can reject this proposal."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestRejectProposalInvalidArgs(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	args := [][]byte{[]byte("rejectProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 500 {
+		t.Errorf("Reject Proposal returned OK status, got: %d, want: %d.", res.Status, 500)
+	}
+	expectedMessage := "Invalid arguments to rejectProposal, expected proposalId, reason."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+}
+
+func TestAcceptProposalSuccess(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	//Clear the event channel
+	nextTestEvent(t, stub)
+
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	args := [][]byte{[]byte("acceptProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid2", args)
+	if res.Status != 200 {
+		t.Errorf("Accept Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	expectedRes := "{\"proposal\":{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"},\"status\":\"ACCEPTED\"," +
+		"\"hash\":\"6b70a820eb978882fa49b199c853a5676e5e1a4744371be5affd4b3af1f5dde6\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500003600," +
+		"\"creator\":{\"mspId\":\"Alice\",\"id\":\"" + testIdentityID("Alice") + "\"}," +
+		"\"acceptance\":{\"txId\":\"txid2\",\"timestamp\":1500000000,\"mspId\":\"Bob\"}}"
+	proposal, err := stub.GetState(proposalPrefix + "prop1234")
+	if err != nil {
+		t.Error("Error getting proposal by id from mock stub")
+	}
+	if string(proposal) != expectedRes {
+		t.Errorf("Accept proposal stored %s, but expected: %s.", string(proposal), expectedRes)
+	}
+	expectedEvent := "{\"events\":[{\"type\":\"ACCEPTANCE\",\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\",\"expiry\":1500003600}]}"
+	proposalAcceptanceEvent := nextTestEvent(t, stub)
+	if proposalAcceptanceEvent == nil {
+		t.Fatal("No proposal acceptance event fired!")
+	}
+	if string(proposalAcceptanceEvent.Payload) != expectedEvent {
+		t.Errorf("Accept proposal fired event with payload %s, but expected %s.", string(proposalAcceptanceEvent.Payload), expectedEvent)
+	}
+	//It can't be accepted again, but can still be confirmed
+	res = stub.MockInvoke("txid3", args)
+	expectedMessage := "Only pending proposals can be accepted."
+	if res.Message != expectedMessage {
+		t.Errorf("Expected Error: %s, got: %s", expectedMessage, res.Message)
+	}
+	args = [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")}
+	res = stub.MockInvoke("txid4", args)
 	if res.Status != 200 {
 		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
 		t.Errorf("Error - %s", res.Message)
 	}
 }
+
+func TestAcceptProposalNotHandler(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	args := [][]byte{[]byte("acceptProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid2", args)
+	expectedMessage := "Only the organisation of the proposal handler can accept this proposal."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Accept Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestAcceptProposalAfterExpiry(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	stub.TxTime = testTime + 60*60 + defaultClockSkewTolerance + 1
+	args := [][]byte{[]byte("acceptProposal"), []byte("prop1234")}
+	res := stub.MockInvoke("txid2", args)
+	expectedMessage := "The timelock on this proposal has expired."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Accept Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+}
+
+func TestConfirmProposalRequiresAcceptance(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	stub.Creator = newTestIdentity(t, "Alice", nil)
+	testProposal := "{\"proposalId\":\"prop1234\",\"proposalHandler\":\"Bob\"}"
+	args := [][]byte{[]byte("createProposal"), []byte(testProposal), []byte(testHashes["SHA256"]), []byte("SHA256"), []byte("{\"requireAcceptance\":true}")}
+	res := stub.MockInvoke("txid1", args)
+	if res.Status != 200 {
+		t.Fatalf("Create Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	confirmArgs := [][]byte{[]byte("confirmProposal"), []byte("prop1234"), []byte("test_hash")}
+	res = stub.MockInvoke("txid2", confirmArgs)
+	expectedMessage := ErrNotAccepted + ": The proposal must be accepted by the handler before it can be confirmed."
+	if res.Status != 500 || res.Message != expectedMessage {
+		t.Errorf("Confirm Proposal returned status %d and error: %s, but expected: %s", res.Status, res.Message, expectedMessage)
+	}
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("acceptProposal"), []byte("prop1234")})
+	if res.Status != 200 {
+		t.Errorf("Accept Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	res = stub.MockInvoke("txid4", confirmArgs)
+	if res.Status != 200 {
+		t.Errorf("Confirm Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+}
+
+func TestRejectAcceptedProposal(t *testing.T) {
+	stub := newTestStub("mockChaincodeStub", new(HashTimeLockContract))
+	createTestProposal(t, stub)
+	stub.Creator = newTestIdentity(t, "Bob", nil)
+	res := stub.MockInvoke("txid2", [][]byte{[]byte("acceptProposal"), []byte("prop1234")})
+	if res.Status != 200 {
+		t.Fatalf("Accept Proposal returned non-OK status, got: %d, want: %d - %s", res.Status, 200, res.Message)
+	}
+	res = stub.MockInvoke("txid3", [][]byte{[]byte("rejectProposal"), []byte("prop1234"), []byte("No route to Charlie")})
+	if res.Status != 200 {
+		t.Errorf("Reject Proposal returned non-OK status, got: %d, want: %d.", res.Status, 200)
+		t.Errorf("Error - %s", res.Message)
+	}
+	if getTestProposal(t, stub, "prop1234").Status != RejectedStatus {
+		t.Errorf("Reject proposal didn't reject the accepted proposal.")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Ledger transport reaching the contract on a channel through the Fabric Go
+ * SDK, for the daemons in cmd.
+ */
+
+package fabric
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/channel"
+	"github.com/hyperledger/fabric-sdk-go/pkg/client/event"
+	"github.com/hyperledger/fabric-sdk-go/pkg/fabsdk"
+)
+
+//Ledger reaches the contract on a channel as a single identity
+type Ledger struct {
+	chaincodeID string
+	client      *channel.Client
+	events      *event.Client
+}
+
+//New connects to the chaincode on the channel as the user from the org
+func New(sdk *fabsdk.FabricSDK, channelID string, chaincodeID string, org string, user string) (*Ledger, error) {
+	channelContext := sdk.ChannelContext(channelID, fabsdk.WithOrg(org), fabsdk.WithUser(user))
+	client, err := channel.New(channelContext)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating channel client - %s", err.Error())
+	}
+	//Block events are needed, as filtered blocks don't carry event payloads
+	eventClient, err := event.New(channelContext, event.WithBlockEvents())
+	if err != nil {
+		return nil, fmt.Errorf("Error creating event client - %s", err.Error())
+	}
+	return &Ledger{chaincodeID: chaincodeID, client: client, events: eventClient}, nil
+}
+
+//Events delivers the chaincode events fired by the contract, through a
+//chaincode event registration which is released once the context is
+//cancelled
+func (l *Ledger) Events(ctx context.Context) (<-chan ledger.Event, error) {
+	registration, notifier, err := l.events.RegisterChaincodeEvent(l.chaincodeID, events.Name)
+	if err != nil {
+		return nil, err
+	}
+	delivered := make(chan ledger.Event)
+	go func() {
+		defer close(delivered)
+		defer l.events.Unregister(registration)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ccEvent, ok := <-notifier:
+				if !ok {
+					return
+				}
+				select {
+				case delivered <- ledger.Event{TxID: ccEvent.TxID, Name: ccEvent.EventName, Payload: ccEvent.Payload}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return delivered, nil
+}
+
+//Invoke submits a transaction to the contract, returning once it has been
+//committed
+func (l *Ledger) Invoke(function string, args ...string) ([]byte, error) {
+	response, err := l.client.Execute(channel.Request{ChaincodeID: l.chaincodeID, Fcn: function, Args: toByteArgs(args)})
+	if err != nil {
+		return nil, err
+	}
+	return response.Payload, nil
+}
+
+//Query evaluates a read-only function of the contract on a peer, without
+//submitting a transaction
+func (l *Ledger) Query(function string, args ...string) ([]byte, error) {
+	response, err := l.client.Query(channel.Request{ChaincodeID: l.chaincodeID, Fcn: function, Args: toByteArgs(args)})
+	if err != nil {
+		return nil, err
+	}
+	return response.Payload, nil
+}
+
+//toByteArgs converts string arguments to the form taken by the SDK
+func toByteArgs(args []string) [][]byte {
+	byteArgs := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		byteArgs = append(byteArgs, []byte(arg))
+	}
+	return byteArgs
+}
@@ -0,0 +1,30 @@
+/*
+ * Transport shared by the clients of the contract, so that they can run
+ * against peers, or against mocks in tests.
+ */
+
+package ledger
+
+import "context"
+
+//Event is a chaincode event fired by the contract on a channel, the events
+//package decodes the payload
+type Event struct {
+	TxID    string
+	Name    string
+	Payload []byte
+}
+
+//Ledger is the transport used to reach the contract on a single channel
+type Ledger interface {
+	//Events delivers the chaincode events fired by the contract, until the
+	//context is cancelled. Only events fired once it has subscribed are
+	//delivered, so clients which also scan state should subscribe before
+	//scanning, so nothing which happens in between is missed.
+	Events(ctx context.Context) (<-chan Event, error)
+	//Invoke submits a transaction to the contract, returning its payload
+	Invoke(function string, args ...string) ([]byte, error)
+	//Query evaluates a read-only function of the contract, returning its
+	//payload
+	Query(function string, args ...string) ([]byte, error)
+}
@@ -0,0 +1,648 @@
+/*
+ * Relayer for the middle-man (Org B) role in a cross-channel swap. It listens
+ * to the contract on two channels. Proposals tagged for it in one channel are
+ * mirrored into the other, and once the mirrored proposal is confirmed, the
+ * pre-image is replayed to confirm the original. If the mirrored proposal is
+ * invalidated or rejected instead, the original is rejected so that it can be
+ * unwound. Originals are found by their hash, so they don't need to share a
+ * proposalId with the mirrored proposal.
+ *
+ * Given a PreImageKey for a channel, proposals relayed into it name the key as
+ * the recipient of the pre-image, so it is only revealed to the relayer, and
+ * the relayer decrypts it before replaying it.
+ *
+ * While either channel is paused, or the handler the relayer would act as in
+ * it is frozen, nothing is relayed between them, as the relayer might not be
+ * able to claim back what it pays out. Once the channel is resumed, or the
+ * handler unfrozen, the relayer catches up as it does on start.
+ *
+ * The relayer keeps no state of its own. On start, the pending proposals
+ * tagged for it are re-scanned from both ledgers, catching up on anything
+ * created, confirmed, invalidated or rejected while it was down, so it can be
+ * restarted freely.
+ */
+
+package relayer
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ecies"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+//Statuses of proposals which the relayer acts upon
+const (
+	pendingStatus     = "PENDING"
+	acceptedStatus    = "ACCEPTED"
+	confirmedStatus   = "CONFIRMED"
+	invalidatedStatus = "INVALIDATED"
+	rejectedStatus    = "REJECTED"
+)
+
+//queryPageSize is the number of proposals requested per page when scanning
+const queryPageSize = 100
+
+//proposalEntry mirrors the proposal returned by getProposal. The proposal
+//itself is kept as raw fields, so that anything the relayer doesn't know
+//about is passed through untouched.
+type proposalEntry struct {
+	Proposal          map[string]json.RawMessage `json:"proposal"`
+	Status            string                     `json:"status"`
+	Hash              string                     `json:"hash"`
+	HashAlgorithm     string                     `json:"hashAlgorithm"`
+	Expiry            int64                      `json:"expiry"`
+	MinPreImageLength int                        `json:"minPreImageLength"`
+	RequireAcceptance bool                       `json:"requireAcceptance"`
+	PreImage          string                     `json:"preImage"`
+	PreImageEncoding  string                     `json:"preImageEncoding"`
+	Invalidation      *transitionRecord          `json:"invalidation"`
+	Rejection         *transitionRecord          `json:"rejection"`
+}
+
+//transitionRecord mirrors the record of why a proposal was invalidated or
+//rejected
+type transitionRecord struct {
+	Reason string `json:"reason"`
+}
+
+//proposalQuery mirrors the filter passed to queryProposals
+type proposalQuery struct {
+	Status   string `json:"status"`
+	Handler  string `json:"proposalHandler"`
+	PageSize int    `json:"pageSize"`
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+//proposalQueryResponse mirrors a page of results from queryProposals
+type proposalQueryResponse struct {
+	Proposals []proposalEntry `json:"proposals"`
+	Bookmark  string          `json:"bookmark"`
+}
+
+//counterpartLink mirrors the evidence of the original passed to
+//createLinkedProposal
+type counterpartLink struct {
+	Channel    string `json:"channel"`
+	ProposalID string `json:"proposalId"`
+	Expiry     int64  `json:"expiry"`
+	TxID       string `json:"txId"`
+}
+
+//contractControls mirrors the emergency controls returned by getControls
+type contractControls struct {
+	Pause          *pauseRecord               `json:"pause"`
+	FrozenHandlers map[string]json.RawMessage `json:"frozenHandlers"`
+}
+
+//pauseRecord mirrors the record of why the contract was paused
+type pauseRecord struct {
+	Reason string `json:"reason"`
+}
+
+//historyEntry mirrors a single modification returned by getProposalHistory
+type historyEntry struct {
+	TxID string `json:"txId"`
+}
+
+//createOptions mirrors the options passed to createProposal
+type createOptions struct {
+	Expiry            string `json:"expiry,omitempty"`
+	Retry             bool   `json:"retry"`
+	MinPreImageLength int    `json:"minPreImageLength,omitempty"`
+	PreImageRecipient string `json:"preImageRecipient,omitempty"`
+}
+
+//Channel is one side of the relay. Proposals in this channel tagged with
+//Handler are relayed to the other channel, and proposals relayed into this
+//channel are tagged with ForwardHandler. When PreImageKey is set, the
+//pre-images of proposals relayed into this channel are encrypted to it.
+type Channel struct {
+	Name           string
+	Ledger         ledger.Ledger
+	Handler        string
+	ForwardHandler string
+	PreImageKey    *ecdsa.PrivateKey
+}
+
+//Relayer relays proposals between two channels
+type Relayer struct {
+	channels     [2]Channel
+	expiryMargin time.Duration
+}
+
+//New creates a relayer between two channels. Relayed proposals expire
+//expiryMargin before the original, leaving time to replay the pre-image.
+func New(one Channel, two Channel, expiryMargin time.Duration) (*Relayer, error) {
+	if one.Name == two.Name {
+		return nil, errors.New("The relayed channels must have different names.")
+	}
+	for _, c := range []Channel{one, two} {
+		if c.Ledger == nil {
+			return nil, fmt.Errorf("No ledger provided for channel %s.", c.Name)
+		}
+		if c.Handler == "" || c.ForwardHandler == "" {
+			return nil, fmt.Errorf("Both handlers must be provided for channel %s.", c.Name)
+		}
+		//Otherwise the relayer would pick up the proposals it creates
+		if c.Handler == c.ForwardHandler {
+			return nil, fmt.Errorf("The handler and forward handler for channel %s must differ.", c.Name)
+		}
+	}
+	if expiryMargin < 0 {
+		return nil, errors.New("The expiry margin cannot be negative.")
+	}
+	return &Relayer{channels: [2]Channel{one, two}, expiryMargin: expiryMargin}, nil
+}
+
+//Run relays events from both channels until the context is cancelled. Events
+//are handled one at a time, and failures are logged rather than stopping the
+//relayer.
+func (r *Relayer) Run(ctx context.Context) error {
+	//Subscribe before scanning, see ledger.Ledger
+	oneEvents, err := r.channels[0].Ledger.Events(ctx)
+	if err != nil {
+		return fmt.Errorf("Error subscribing to events on channel %s - %s", r.channels[0].Name, err.Error())
+	}
+	twoEvents, err := r.channels[1].Ledger.Events(ctx)
+	if err != nil {
+		return fmt.Errorf("Error subscribing to events on channel %s - %s", r.channels[1].Name, err.Error())
+	}
+	err = r.Rescan()
+	if err != nil {
+		log.Printf("Error rescanning proposals - %s", err.Error())
+	}
+	for {
+		var channelName string
+		var event ledger.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok = <-oneEvents:
+			channelName = r.channels[0].Name
+		case event, ok = <-twoEvents:
+			channelName = r.channels[1].Name
+		}
+		if !ok {
+			return fmt.Errorf("The event stream for channel %s closed.", channelName)
+		}
+		err = r.HandleEvent(channelName, event)
+		if err != nil {
+			log.Printf("Error relaying transaction %s from channel %s - %s", event.TxID, channelName, err.Error())
+		}
+	}
+}
+
+//Rescan catches up on every pending proposal tagged for the relayer, in both
+//channels. Those which haven't been mirrored are mirrored, and those whose
+//mirrored proposal has since been settled are confirmed or rejected to
+//match. Every proposal is attempted, and the first failure is returned.
+func (r *Relayer) Rescan() error {
+	var firstErr error
+	for i := range r.channels {
+		source, target := r.channels[i], r.channels[1-i]
+		originals, err := pendingFor(source)
+		if err != nil {
+			return err
+		}
+		for _, original := range originals {
+			err = catchUp(source, target, original, func(proposalID string) error {
+				return r.mirror(source, target, proposalID)
+			})
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+//catchUp brings a pending original in the source channel in line with its
+//mirrored proposals in the target channel, using mirror to create one if
+//there are none
+func catchUp(source Channel, target Channel, original proposalEntry, mirror func(proposalID string) error) error {
+	originalID, err := original.field("proposalId")
+	if err != nil {
+		return err
+	}
+	mirrored, err := relayedBy(target, original.Hash)
+	if err != nil {
+		return err
+	}
+	if len(mirrored) == 0 {
+		err = mirror(originalID)
+		if err != nil {
+			return fmt.Errorf("Error mirroring proposal %s - %s", originalID, err.Error())
+		}
+		return nil
+	}
+	for _, proposal := range mirrored {
+		switch {
+		case proposal.Status == confirmedStatus:
+			err = confirmAll(target, source, original.Hash, proposal.PreImage, proposal.PreImageEncoding)
+		case proposal.Status == invalidatedStatus && proposal.Invalidation != nil:
+			err = unwind(target, source, original.Hash, proposal.Invalidation.Reason)
+		case proposal.Status == rejectedStatus && proposal.Rejection != nil:
+			err = unwind(target, source, original.Hash, proposal.Rejection.Reason)
+		default:
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error settling proposal %s - %s", originalID, err.Error())
+		}
+		//Everything waiting on the hash has been settled
+		return nil
+	}
+	return nil
+}
+
+//HandleEvent relays a single event received from the named channel. Every
+//sub-event is attempted, and the first failure is returned.
+func (r *Relayer) HandleEvent(channelName string, event ledger.Event) error {
+	if event.Name != events.Name {
+		return nil
+	}
+	var source, target Channel
+	switch channelName {
+	case r.channels[0].Name:
+		source, target = r.channels[0], r.channels[1]
+	case r.channels[1].Name:
+		source, target = r.channels[1], r.channels[0]
+	default:
+		return fmt.Errorf("Unknown channel %s.", channelName)
+	}
+	envelope, err := events.Decode(event.Payload)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, subEvent := range envelope.Events {
+		err = nil
+		handler := subEvent.Handler
+		if handler == "" && subEvent.ProposalID != "" && subEvent.Type != events.TimeoutRegistration {
+			handler, err = handlerOf(source, subEvent.ProposalID)
+		}
+		switch {
+		case err != nil:
+		case subEvent.Type == events.Pause || subEvent.Type == events.Freeze:
+			logControl(source, subEvent)
+		case subEvent.Type == events.Resume || subEvent.Type == events.Unfreeze:
+			logControl(source, subEvent)
+			err = r.Rescan()
+		case subEvent.Type == events.HandlerNotification && handler == source.Handler:
+			err = r.mirror(source, target, subEvent.ProposalID)
+		case subEvent.Type == events.Confirmation && handler == source.ForwardHandler:
+			err = r.confirm(source, target, subEvent.ProposalID, subEvent.PreImage, subEvent.PreImageEncoding)
+		case (subEvent.Type == events.Invalidation || subEvent.Type == events.Rejection) && handler == source.ForwardHandler:
+			err = unwind(source, target, subEvent.Hash, subEvent.Reason)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Error handling %s for proposal %s - %s", subEvent.Type, subEvent.ProposalID, err.Error())
+		}
+	}
+	return firstErr
+}
+
+//mirror creates a copy of a proposal from the source channel in the target
+//channel, expiring expiryMargin before the original. It is linked to the
+//original, with the transaction which created it as evidence, so the
+//contract checks it expires safely first. Routed proposals are left to a
+//Router.
+func (r *Relayer) mirror(source Channel, target Channel, proposalID string) error {
+	proposal, err := getProposal(source.Ledger, proposalID)
+	if err != nil {
+		return err
+	}
+	if !isOpen(proposal.Status) || proposal.Proposal["route"] != nil {
+		return nil
+	}
+	link := &counterpartLink{Channel: source.Name, ProposalID: proposalID, Expiry: proposal.Expiry}
+	link.TxID, err = creationTxID(source.Ledger, proposalID)
+	if err != nil {
+		return err
+	}
+	expiry := time.Unix(proposal.Expiry, 0).Add(-r.expiryMargin).UTC()
+	return relay(source, target, proposalID, proposal, createOptions{Expiry: expiry.Format(time.RFC3339)}, link)
+}
+
+//relay creates a copy of a proposal from the source channel in the target
+//channel, tagged with the forward handler and keeping its minimum pre-image
+//length, and linked to the original if a link is given. The pre-image is
+//encrypted to the PreImageKey of the target channel, if it has one. It is
+//submitted as a retry, so relaying the same proposal twice is harmless.
+//Originals which require acceptance are accepted first, so they can be
+//confirmed once the pre-image is replayed. Nothing is relayed while either
+//channel is halted, or for proposals locking an asset, as the relayer has no
+//asset to lock in its place.
+func relay(source Channel, target Channel, proposalID string, proposal proposalEntry, options createOptions, link *counterpartLink) error {
+	if proposal.Proposal["assetId"] != nil {
+		log.Printf("Not relaying proposal %s from channel %s to channel %s, as it locks an asset.", proposalID, source.Name, target.Name)
+		return nil
+	}
+	reason, err := haltedBy(source, source.Handler)
+	if err == nil && reason == "" {
+		reason, err = haltedBy(target, target.ForwardHandler)
+	}
+	if err != nil {
+		return err
+	}
+	if reason != "" {
+		log.Printf("Not relaying proposal %s from channel %s to channel %s, as %s.", proposalID, source.Name, target.Name, reason)
+		return nil
+	}
+	if proposal.RequireAcceptance && proposal.Status == pendingStatus {
+		_, err = source.Ledger.Invoke("acceptProposal", proposalID)
+		if err != nil {
+			return fmt.Errorf("Error accepting the original proposal - %s", err.Error())
+		}
+	}
+	//The relayed proposal is handled in the target channel, and points back
+	//at the original as its counterpart. It is paid for by the relayer, and
+	//pays the forward handler, so the parties of the original don't apply.
+	delete(proposal.Proposal, "originator")
+	delete(proposal.Proposal, "beneficiary")
+	proposal.Proposal["proposalHandler"], err = json.Marshal(target.ForwardHandler)
+	if err != nil {
+		return err
+	}
+	proposal.Proposal["counterpartChannel"], err = json.Marshal(source.Name)
+	if err != nil {
+		return err
+	}
+	proposal.Proposal["counterpartProposalId"], err = json.Marshal(proposalID)
+	if err != nil {
+		return err
+	}
+	proposalAsBytes, err := json.Marshal(proposal.Proposal)
+	if err != nil {
+		return fmt.Errorf("Error building relayed proposal - %s", err.Error())
+	}
+	options.Retry = true
+	options.MinPreImageLength = proposal.MinPreImageLength
+	if target.PreImageKey != nil {
+		options.PreImageRecipient, err = ecies.MarshalPublicKey(&target.PreImageKey.PublicKey)
+		if err != nil {
+			return err
+		}
+	}
+	optionsAsBytes, err := json.Marshal(options)
+	if err != nil {
+		return fmt.Errorf("Error building relayed proposal options - %s", err.Error())
+	}
+	if link == nil {
+		_, err = target.Ledger.Invoke("createProposal", string(proposalAsBytes), proposal.Hash, proposal.HashAlgorithm, string(optionsAsBytes))
+		return err
+	}
+	linkAsBytes, err := json.Marshal(link)
+	if err != nil {
+		return fmt.Errorf("Error building link to the original proposal - %s", err.Error())
+	}
+	_, err = target.Ledger.Invoke("createLinkedProposal", string(proposalAsBytes), proposal.Hash, proposal.HashAlgorithm, string(linkAsBytes), string(optionsAsBytes))
+	return err
+}
+
+//confirm replays a pre-image into the target channel, for every proposal
+//there with the same hash which is still waiting on the relayer
+func (r *Relayer) confirm(source Channel, target Channel, proposalID string, preImage string, encoding string) error {
+	proposal, err := getProposal(source.Ledger, proposalID)
+	if err != nil {
+		return err
+	}
+	return confirmAll(source, target, proposal.Hash, preImage, encoding)
+}
+
+//confirmAll replays a pre-image revealed in the source channel to every
+//proposal in the target channel locked with the hash which is still waiting
+//on the relayer. The encoding is passed on as given, so the same bytes are
+//hashed in both channels, unless the pre-image was encrypted to the
+//PreImageKey of the source channel, when it is decrypted first.
+func confirmAll(source Channel, target Channel, hash string, preImage string, encoding string) error {
+	preImage, encoding, err := ecies.Reveal(source.PreImageKey, preImage, encoding)
+	if err != nil {
+		return err
+	}
+	awaiting, err := awaitingRelay(target, hash)
+	if err != nil {
+		return err
+	}
+	for _, awaitingID := range awaiting {
+		if encoding == "" {
+			_, err = target.Ledger.Invoke("confirmProposal", awaitingID, preImage)
+		} else {
+			_, err = target.Ledger.Invoke("confirmProposal", awaitingID, preImage, encoding)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//unwind rejects the original proposals in the target channel once the
+//relayed proposal in the source channel, with the hash, can no longer be
+//confirmed
+func unwind(source Channel, target Channel, hash string, reason string) error {
+	awaiting, err := awaitingRelay(target, hash)
+	if err != nil {
+		return err
+	}
+	reason = fmt.Sprintf("The relayed proposal in channel %s was not confirmed - %s", source.Name, reason)
+	for _, awaitingID := range awaiting {
+		_, err = target.Ledger.Invoke("rejectProposal", awaitingID, reason)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//awaitingRelay finds the proposals in the channel locked with the hash which
+//are still pending or accepted, and tagged for the relayer
+func awaitingRelay(channel Channel, hash string) ([]string, error) {
+	proposals, err := proposalsByHash(channel, hash, channel.Handler)
+	if err != nil {
+		return nil, err
+	}
+	awaiting := []string{}
+	for _, proposal := range proposals {
+		if !isOpen(proposal.Status) {
+			continue
+		}
+		proposalID, err := proposal.field("proposalId")
+		if err != nil {
+			return nil, err
+		}
+		awaiting = append(awaiting, proposalID)
+	}
+	return awaiting, nil
+}
+
+//relayedBy finds the proposals in the channel locked with the hash which the
+//relayer created, tagged with the forward handler
+func relayedBy(channel Channel, hash string) ([]proposalEntry, error) {
+	return proposalsByHash(channel, hash, channel.ForwardHandler)
+}
+
+//proposalsByHash finds the proposals in the channel locked with the hash,
+//and tagged with the handler
+func proposalsByHash(channel Channel, hash string, handler string) ([]proposalEntry, error) {
+	proposalsAsBytes, err := channel.Ledger.Query("getProposalsByHash", hash)
+	if err != nil {
+		return nil, err
+	}
+	proposals := []proposalEntry{}
+	err = json.Unmarshal(proposalsAsBytes, &proposals)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing proposals - %s", err.Error())
+	}
+	matched := []proposalEntry{}
+	for _, proposal := range proposals {
+		proposalHandler, err := proposal.field("proposalHandler")
+		if err != nil {
+			return nil, err
+		}
+		if proposalHandler == handler {
+			matched = append(matched, proposal)
+		}
+	}
+	return matched, nil
+}
+
+//pendingFor pages through the pending and accepted proposals in the channel
+//tagged for the relayer
+func pendingFor(channel Channel) ([]proposalEntry, error) {
+	pending := []proposalEntry{}
+	for _, status := range []string{pendingStatus, acceptedStatus} {
+		query := proposalQuery{Status: status, Handler: channel.Handler, PageSize: queryPageSize}
+		for {
+			queryAsBytes, err := json.Marshal(query)
+			if err != nil {
+				return nil, fmt.Errorf("Error building query - %s", err.Error())
+			}
+			responseAsBytes, err := channel.Ledger.Query("queryProposals", string(queryAsBytes))
+			if err != nil {
+				return nil, fmt.Errorf("Error querying pending proposals on channel %s - %s", channel.Name, err.Error())
+			}
+			response := proposalQueryResponse{}
+			err = json.Unmarshal(responseAsBytes, &response)
+			if err != nil {
+				return nil, fmt.Errorf("Error parsing pending proposals - %s", err.Error())
+			}
+			pending = append(pending, response.Proposals...)
+			if response.Bookmark == "" {
+				break
+			}
+			query.Bookmark = response.Bookmark
+		}
+	}
+	return pending, nil
+}
+
+//isOpen checks whether a proposal with the status can still be confirmed,
+//invalidated or rejected
+func isOpen(status string) bool {
+	return status == pendingStatus || status == acceptedStatus
+}
+
+//field reads a string field of the proposal definition
+func (proposal proposalEntry) field(name string) (string, error) {
+	var value string
+	err := json.Unmarshal(proposal.Proposal[name], &value)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing %s - %s", name, err.Error())
+	}
+	return value, nil
+}
+
+//handlerOf reads the handler of a proposal, for events which don't name it,
+//as is the case for private proposals. It is empty if the relayer isn't
+//allowed to see the private part of the proposal.
+func handlerOf(channel Channel, proposalID string) (string, error) {
+	proposal, err := getProposal(channel.Ledger, proposalID)
+	if err != nil {
+		return "", err
+	}
+	if proposal.Proposal["proposalHandler"] == nil {
+		return "", nil
+	}
+	return proposal.field("proposalHandler")
+}
+
+//creationTxID finds the transaction which created a proposal, the first in
+//its history
+func creationTxID(channelLedger ledger.Ledger, proposalID string) (string, error) {
+	historyAsBytes, err := channelLedger.Query("getProposalHistory", proposalID)
+	if err != nil {
+		return "", err
+	}
+	history := []historyEntry{}
+	err = json.Unmarshal(historyAsBytes, &history)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing proposal history - %s", err.Error())
+	}
+	if len(history) == 0 {
+		return "", errors.New("The proposal has no history.")
+	}
+	return history[0].TxID, nil
+}
+
+//haltedBy checks whether the contract in the channel is paused, or the
+//handler frozen, giving the reason relaying is halted, or an empty string if
+//it isn't
+func haltedBy(channel Channel, handler string) (string, error) {
+	controlsAsBytes, err := channel.Ledger.Query("getControls")
+	if err != nil {
+		return "", fmt.Errorf("Error querying the controls on channel %s - %s", channel.Name, err.Error())
+	}
+	controls := contractControls{}
+	err = json.Unmarshal(controlsAsBytes, &controls)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing controls - %s", err.Error())
+	}
+	if controls.Pause != nil {
+		return fmt.Sprintf("channel %s is paused - %s", channel.Name, controls.Pause.Reason), nil
+	}
+	if _, ok := controls.FrozenHandlers[handler]; ok {
+		return fmt.Sprintf("handler %s is frozen in channel %s", handler, channel.Name), nil
+	}
+	return "", nil
+}
+
+//logControl logs a change to the emergency controls in the channel
+func logControl(channel Channel, subEvent events.Event) {
+	switch subEvent.Type {
+	case events.Pause:
+		log.Printf("Channel %s was paused - %s", channel.Name, subEvent.Reason)
+	case events.Resume:
+		log.Printf("Channel %s was resumed - %s", channel.Name, subEvent.Reason)
+	case events.Freeze:
+		log.Printf("Handler %s was frozen in channel %s - %s", subEvent.Handler, channel.Name, subEvent.Reason)
+	case events.Unfreeze:
+		log.Printf("Handler %s was unfrozen in channel %s - %s", subEvent.Handler, channel.Name, subEvent.Reason)
+	}
+}
+
+//getProposal retrieves a proposal from the ledger
+func getProposal(channelLedger ledger.Ledger, proposalID string) (proposalEntry, error) {
+	proposal := proposalEntry{}
+	proposalAsBytes, err := channelLedger.Query("getProposal", proposalID)
+	if err != nil {
+		return proposal, err
+	}
+	err = json.Unmarshal(proposalAsBytes, &proposal)
+	if err != nil {
+		return proposal, fmt.Errorf("Error parsing proposal - %s", err.Error())
+	}
+	if proposal.Proposal == nil {
+		return proposal, errors.New("The proposal has no definition.")
+	}
+	return proposal, nil
+}
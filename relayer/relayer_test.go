@@ -0,0 +1,283 @@
+package relayer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+//fakeLedger serves fixed proposals, by proposalId, by hash and as a single
+//page of pending proposals, with no accepted proposals, and the controls in
+//force, and records the transactions invoked
+type fakeLedger struct {
+	proposals map[string]string
+	byHash    map[string]string
+	pending   string
+	controls  string
+	invoked   []string
+}
+
+func (l *fakeLedger) Events(ctx context.Context) (<-chan ledger.Event, error) {
+	return make(chan ledger.Event), nil
+}
+
+func (l *fakeLedger) Invoke(function string, args ...string) ([]byte, error) {
+	l.invoked = append(l.invoked, function+"("+strings.Join(args, ",")+")")
+	return nil, nil
+}
+
+func (l *fakeLedger) Query(function string, args ...string) ([]byte, error) {
+	if function == "queryProposals" {
+		if l.pending == "" || !strings.Contains(args[0], "\"status\":\"PENDING\"") {
+			return []byte("{\"proposals\":[],\"bookmark\":\"\"}"), nil
+		}
+		return []byte(l.pending), nil
+	}
+	if function == "getControls" {
+		if l.controls == "" {
+			return []byte("{}"), nil
+		}
+		return []byte(l.controls), nil
+	}
+	if function == "getProposalHistory" {
+		return []byte("[{\"txId\":\"tx-" + args[0] + "\"}]"), nil
+	}
+	if function == "getProposalsByHash" {
+		proposals, ok := l.byHash[args[0]]
+		if !ok {
+			return []byte("[]"), nil
+		}
+		return []byte(proposals), nil
+	}
+	proposal, ok := l.proposals[args[0]]
+	if !ok {
+		return nil, errors.New("No such proposal.")
+	}
+	return []byte(proposal), nil
+}
+
+func newFakeRelayer(t *testing.T, one *fakeLedger, two *fakeLedger) *Relayer {
+	r, err := New(
+		Channel{Name: "one", Ledger: one, Handler: "Bob", ForwardHandler: "Alice"},
+		Channel{Name: "two", Ledger: two, Handler: "Bob", ForwardHandler: "Charlie"},
+		time.Hour,
+	)
+	if err != nil {
+		t.Fatalf("Relayer creation failed - %s", err.Error())
+	}
+	return r
+}
+
+func TestMirrorKeepsProposalFields(t *testing.T) {
+	one := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"originator\":\"Alice\",\"beneficiary\":\"Bob\",\"assetType\":\"GBP\",\"amount\":10},\"status\":\"PENDING\"," +
+			"\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200,\"minPreImageLength\":32}",
+	}}
+	two := &fakeLedger{}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[" +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}," +
+		"{\"type\":\"TIMEOUT_REGISTRATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}]}"
+	err := r.HandleEvent("one", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	expected := "createLinkedProposal({\"amount\":10,\"assetType\":\"GBP\",\"counterpartChannel\":\"one\",\"counterpartProposalId\":\"prop1\"," +
+		"\"proposalHandler\":\"Charlie\",\"proposalId\":\"prop1\"},hash,SHA256," +
+		"{\"channel\":\"one\",\"proposalId\":\"prop1\",\"expiry\":1500007200,\"txId\":\"tx-prop1\"}," +
+		"{\"expiry\":\"2017-07-14T03:40:00Z\",\"retry\":true,\"minPreImageLength\":32})"
+	if len(two.invoked) != 1 || two.invoked[0] != expected {
+		t.Errorf("Relayer invoked %v, but expected %s.", two.invoked, expected)
+	}
+}
+
+func TestConfirmReplaysEncoding(t *testing.T) {
+	one := &fakeLedger{byHash: map[string]string{
+		"hash": "[{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"}]",
+	}}
+	two := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Charlie\"},\"status\":\"CONFIRMED\",\"hash\":\"hash\"}",
+	}}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[" +
+		"{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Charlie\",\"preImage\":\"3q2+7w==\",\"preImageEncoding\":\"base64\"}]}"
+	err := r.HandleEvent("two", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	expected := "confirmProposal(prop1,3q2+7w==,base64)"
+	if len(one.invoked) != 1 || one.invoked[0] != expected {
+		t.Errorf("Relayer invoked %v, but expected %s.", one.invoked, expected)
+	}
+}
+
+func TestConfirmFindsOriginalsByHash(t *testing.T) {
+	//The originals don't share an id with the relayed proposal, and only those
+	//still waiting on the relayer are confirmed
+	one := &fakeLedger{byHash: map[string]string{
+		"hash": "[{\"proposal\":{\"proposalId\":\"a-1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"}," +
+			"{\"proposal\":{\"proposalId\":\"a-2\",\"proposalHandler\":\"Bob\"},\"status\":\"CONFIRMED\"}," +
+			"{\"proposal\":{\"proposalId\":\"a-3\",\"proposalHandler\":\"Dave\"},\"status\":\"PENDING\"}," +
+			"{\"proposal\":{\"proposalId\":\"a-4\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"}]",
+	}}
+	two := &fakeLedger{proposals: map[string]string{
+		"c-1": "{\"proposal\":{\"proposalId\":\"c-1\",\"proposalHandler\":\"Charlie\"},\"status\":\"CONFIRMED\",\"hash\":\"hash\"}",
+	}}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[" +
+		"{\"type\":\"CONFIRMATION\",\"proposalId\":\"c-1\",\"proposalHandler\":\"Charlie\",\"preImage\":\"secret\",\"preImageEncoding\":\"raw\"}]}"
+	err := r.HandleEvent("two", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	expected := []string{"confirmProposal(a-1,secret,raw)", "confirmProposal(a-4,secret,raw)"}
+	if strings.Join(one.invoked, ";") != strings.Join(expected, ";") {
+		t.Errorf("Relayer invoked %v, but expected %v.", one.invoked, expected)
+	}
+}
+
+func TestIgnoresOtherEvents(t *testing.T) {
+	one := &fakeLedger{}
+	two := &fakeLedger{}
+	r := newFakeRelayer(t, one, two)
+	//Proposals for other handlers, and confirmations not relayed by us
+	payload := "{\"events\":[" +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Dave\"}," +
+		"{\"type\":\"CONFIRMATION\",\"proposalId\":\"prop2\",\"proposalHandler\":\"Bob\",\"preImage\":\"secret\"}]}"
+	err := r.HandleEvent("two", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Errorf("Relayer failed to handle event - %s", err.Error())
+	}
+	err = r.HandleEvent("two", ledger.Event{Name: "SOMETHING_ELSE", Payload: []byte("not json")})
+	if err != nil {
+		t.Errorf("Relayer failed to ignore event - %s", err.Error())
+	}
+	if len(one.invoked) != 0 || len(two.invoked) != 0 {
+		t.Errorf("Relayer invoked %v and %v, but expected nothing.", one.invoked, two.invoked)
+	}
+}
+
+func TestUnknownChannel(t *testing.T) {
+	r := newFakeRelayer(t, &fakeLedger{}, &fakeLedger{})
+	err := r.HandleEvent("three", ledger.Event{Name: events.Name, Payload: []byte("{\"events\":[]}")})
+	if err == nil {
+		t.Error("Relayer handled an event from an unknown channel, but expected an error.")
+	}
+}
+
+func TestRescanMirrorsMissedProposals(t *testing.T) {
+	original := "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"," +
+		"\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}"
+	one := &fakeLedger{
+		proposals: map[string]string{"prop1": original},
+		pending:   "{\"proposals\":[" + original + "],\"bookmark\":\"\"}",
+	}
+	two := &fakeLedger{}
+	r := newFakeRelayer(t, one, two)
+	err := r.Rescan()
+	if err != nil {
+		t.Fatalf("Relayer failed to rescan - %s", err.Error())
+	}
+	if len(two.invoked) != 1 || !strings.HasPrefix(two.invoked[0], "createLinkedProposal(") {
+		t.Errorf("Relayer invoked %v, but expected the missed proposal to be mirrored.", two.invoked)
+	}
+}
+
+func TestRescanReplaysMissedSettlements(t *testing.T) {
+	one := &fakeLedger{
+		pending: "{\"proposals\":[" +
+			"{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"hash1\"}," +
+			"{\"proposal\":{\"proposalId\":\"prop2\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"hash2\"}],\"bookmark\":\"\"}",
+		byHash: map[string]string{
+			"hash1": "[{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"}]",
+			"hash2": "[{\"proposal\":{\"proposalId\":\"prop2\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"}]",
+		},
+	}
+	//While the relayer was down, one relayed proposal was confirmed and the
+	//other rejected
+	two := &fakeLedger{byHash: map[string]string{
+		"hash1": "[{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Charlie\"},\"status\":\"CONFIRMED\"," +
+			"\"preImage\":\"3q2+7w==\",\"preImageEncoding\":\"base64\"}]",
+		"hash2": "[{\"proposal\":{\"proposalId\":\"prop2\",\"proposalHandler\":\"Charlie\"},\"status\":\"REJECTED\"," +
+			"\"rejection\":{\"reason\":\"No thanks\"}}]",
+	}}
+	r := newFakeRelayer(t, one, two)
+	err := r.Rescan()
+	if err != nil {
+		t.Fatalf("Relayer failed to rescan - %s", err.Error())
+	}
+	expected := []string{"confirmProposal(prop1,3q2+7w==,base64)", "rejectProposal(prop2,The relayed proposal in channel two was not confirmed - No thanks)"}
+	if strings.Join(one.invoked, ";") != strings.Join(expected, ";") {
+		t.Errorf("Relayer invoked %v, but expected %v.", one.invoked, expected)
+	}
+	if len(two.invoked) != 0 {
+		t.Errorf("Relayer invoked %v in channel two, but expected nothing.", two.invoked)
+	}
+}
+
+func TestSkipsAssetProposals(t *testing.T) {
+	one := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"assetId\":\"painting\"},\"status\":\"PENDING\",\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}",
+	}}
+	two := &fakeLedger{}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}]}"
+	err := r.HandleEvent("one", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	if len(two.invoked) != 0 {
+		t.Errorf("Relayer invoked %v for a proposal locking an asset, but expected nothing.", two.invoked)
+	}
+}
+
+func TestHaltedWhilePaused(t *testing.T) {
+	one := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}",
+	}}
+	two := &fakeLedger{controls: "{\"pause\":{\"reason\":\"Investigating\"}}"}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}]}"
+	err := r.HandleEvent("one", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	if len(two.invoked) != 0 {
+		t.Errorf("Relayer invoked %v in a paused channel, but expected nothing.", two.invoked)
+	}
+	//Once resumed, the relayer catches up on what it skipped
+	two.controls = ""
+	one.pending = "{\"proposals\":[" + one.proposals["prop1"] + "],\"bookmark\":\"\"}"
+	payload = "{\"events\":[{\"type\":\"RESUME\",\"proposalId\":\"\",\"proposalHandler\":\"\",\"channel\":\"two\"}]}"
+	err = r.HandleEvent("two", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	if len(two.invoked) != 1 || !strings.HasPrefix(two.invoked[0], "createLinkedProposal(") {
+		t.Errorf("Relayer invoked %v after resuming, but expected the proposal to be mirrored.", two.invoked)
+	}
+}
+
+func TestHaltedWhileHandlerFrozen(t *testing.T) {
+	one := &fakeLedger{
+		proposals: map[string]string{
+			"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\",\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}",
+		},
+		controls: "{\"frozenHandlers\":{\"Bob\":{\"reason\":\"Compromised\"}}}",
+	}
+	two := &fakeLedger{}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}]}"
+	err := r.HandleEvent("one", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	if len(two.invoked) != 0 {
+		t.Errorf("Relayer invoked %v for a frozen handler, but expected nothing.", two.invoked)
+	}
+}
@@ -0,0 +1,298 @@
+/*
+ * Router for intermediaries of multi-hop payments. Rather than mirroring
+ * between a fixed pair of channels, it follows the route carried by each
+ * proposal. A routed proposal tagged for the router in one channel is
+ * forwarded to the next channel of the route, handled by the next hop, and
+ * expiring as the route says. Once the forwarded proposal is confirmed, the
+ * pre-image is replayed back to confirm the proposal which paid the router,
+ * and if it is invalidated or rejected instead, that proposal is rejected.
+ *
+ * Like the Relayer, the router keeps no state of its own, and re-scans the
+ * pending proposals tagged for it on start. It doesn't forward proposals from
+ * or into a channel while it is halted, catching up once it is resumed.
+ */
+
+package relayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+//routeHop mirrors a single hop of the route carried by a proposal
+type routeHop struct {
+	Channel string `json:"channel"`
+	Handler string `json:"proposalHandler"`
+	Expiry  int64  `json:"expiry"`
+}
+
+//Router forwards routed proposals hop-by-hop between any number of channels
+type Router struct {
+	channels map[string]Channel
+}
+
+//channelEvent is an event received from the named channel, or the end of its
+//stream when closed is set
+type channelEvent struct {
+	channelName string
+	event       ledger.Event
+	closed      bool
+}
+
+//NewRouter creates a router across the channels. The Handler of each channel
+//is the handler the router acts as in that channel, the ForwardHandler isn't
+//used, as the handler of each hop is taken from the route.
+func NewRouter(channels ...Channel) (*Router, error) {
+	if len(channels) < 2 {
+		return nil, errors.New("A router needs at least two channels.")
+	}
+	r := &Router{channels: map[string]Channel{}}
+	for _, c := range channels {
+		if c.Ledger == nil {
+			return nil, fmt.Errorf("No ledger provided for channel %s.", c.Name)
+		}
+		if c.Handler == "" {
+			return nil, fmt.Errorf("A handler must be provided for channel %s.", c.Name)
+		}
+		if _, ok := r.channels[c.Name]; ok {
+			return nil, fmt.Errorf("Channel %s was provided more than once.", c.Name)
+		}
+		r.channels[c.Name] = c
+	}
+	return r, nil
+}
+
+//Run routes events from every channel until the context is cancelled.
+//Events are handled one at a time, and failures are logged rather than
+//stopping the router.
+func (r *Router) Run(ctx context.Context) error {
+	//Subscribe before scanning, see ledger.Ledger
+	merged := make(chan channelEvent)
+	for _, name := range r.channelNames() {
+		delivered, err := r.channels[name].Ledger.Events(ctx)
+		if err != nil {
+			return fmt.Errorf("Error subscribing to events on channel %s - %s", name, err.Error())
+		}
+		go forwardEvents(ctx, name, delivered, merged)
+	}
+	err := r.Rescan()
+	if err != nil {
+		log.Printf("Error rescanning proposals - %s", err.Error())
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case received := <-merged:
+			if received.closed {
+				return fmt.Errorf("The event stream for channel %s closed.", received.channelName)
+			}
+			err = r.HandleEvent(received.channelName, received.event)
+			if err != nil {
+				log.Printf("Error routing transaction %s from channel %s - %s", received.event.TxID, received.channelName, err.Error())
+			}
+		}
+	}
+}
+
+//forwardEvents passes the events from one channel on to the merged stream,
+//marking the end of the stream if it closes
+func forwardEvents(ctx context.Context, channelName string, delivered <-chan ledger.Event, merged chan<- channelEvent) {
+	for {
+		received := channelEvent{channelName: channelName}
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-delivered:
+			received.event, received.closed = event, !ok
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case merged <- received:
+		}
+		if received.closed {
+			return
+		}
+	}
+}
+
+//Rescan catches up on every pending routed proposal tagged for the router,
+//in every channel. Those which haven't been forwarded are forwarded, and
+//those whose forwarded proposal has since been settled are confirmed or
+//rejected to match. Every proposal is attempted, and the first failure is
+//returned.
+func (r *Router) Rescan() error {
+	var firstErr error
+	for _, name := range r.channelNames() {
+		source := r.channels[name]
+		originals, err := pendingFor(source)
+		if err != nil {
+			return err
+		}
+		for _, original := range originals {
+			target, ok, err := r.nextHop(source, original)
+			if err == nil && ok {
+				err = catchUp(source, target, original, func(proposalID string) error {
+					return r.forward(source, proposalID)
+				})
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+//HandleEvent routes a single event received from the named channel. Every
+//sub-event is attempted, and the first failure is returned.
+func (r *Router) HandleEvent(channelName string, event ledger.Event) error {
+	if event.Name != events.Name {
+		return nil
+	}
+	source, ok := r.channels[channelName]
+	if !ok {
+		return fmt.Errorf("Unknown channel %s.", channelName)
+	}
+	envelope, err := events.Decode(event.Payload)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	for _, subEvent := range envelope.Events {
+		err = nil
+		switch subEvent.Type {
+		case events.HandlerNotification:
+			handler := subEvent.Handler
+			if handler == "" {
+				handler, err = handlerOf(source, subEvent.ProposalID)
+			}
+			if err == nil && handler == source.Handler {
+				err = r.forward(source, subEvent.ProposalID)
+			}
+		case events.Confirmation, events.Invalidation, events.Rejection:
+			err = r.propagate(source, subEvent)
+		case events.Pause, events.Freeze:
+			logControl(source, subEvent)
+		case events.Resume, events.Unfreeze:
+			logControl(source, subEvent)
+			err = r.Rescan()
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("Error handling %s for proposal %s - %s", subEvent.Type, subEvent.ProposalID, err.Error())
+		}
+	}
+	return firstErr
+}
+
+//forward creates a routed proposal from the source channel in the channel of
+//the next hop of its route, which sets its handler and expiry
+func (r *Router) forward(source Channel, proposalID string) error {
+	proposal, err := getProposal(source.Ledger, proposalID)
+	if err != nil {
+		return err
+	}
+	if !isOpen(proposal.Status) {
+		return nil
+	}
+	target, ok, err := r.nextHop(source, proposal)
+	if err != nil || !ok {
+		return err
+	}
+	return relay(source, target, proposalID, proposal, createOptions{}, nil)
+}
+
+//propagate passes the settlement of a proposal the router forwarded back to
+//the proposal which paid the router, in the channel of the previous hop
+func (r *Router) propagate(source Channel, subEvent events.Event) error {
+	proposal, err := getProposal(source.Ledger, subEvent.ProposalID)
+	if err != nil {
+		return err
+	}
+	upstream, ok, err := r.previousHop(source, proposal)
+	if err != nil || !ok {
+		return err
+	}
+	if subEvent.Type == events.Confirmation {
+		return confirmAll(source, upstream, proposal.Hash, subEvent.PreImage, subEvent.PreImageEncoding)
+	}
+	return unwind(source, upstream, proposal.Hash, subEvent.Reason)
+}
+
+//nextHop finds the channel to forward a proposal to, when the router handles
+//it in the source channel and the route continues. The target channel is
+//returned with the handler of the next hop as its ForwardHandler.
+func (r *Router) nextHop(source Channel, proposal proposalEntry) (Channel, bool, error) {
+	route, err := proposal.route()
+	if err != nil {
+		return Channel{}, false, err
+	}
+	for i := 0; i < len(route)-1; i++ {
+		if route[i].Channel != source.Name || route[i].Handler != source.Handler {
+			continue
+		}
+		target, ok := r.channels[route[i+1].Channel]
+		if !ok {
+			return Channel{}, false, fmt.Errorf("The router isn't connected to channel %s, the next hop of the route.", route[i+1].Channel)
+		}
+		target.ForwardHandler = route[i+1].Handler
+		return target, true, nil
+	}
+	return Channel{}, false, nil
+}
+
+//previousHop finds the channel holding the proposal which paid the router,
+//when the router forwarded the proposal in the source channel
+func (r *Router) previousHop(source Channel, proposal proposalEntry) (Channel, bool, error) {
+	route, err := proposal.route()
+	if err != nil {
+		return Channel{}, false, err
+	}
+	handler, err := proposal.field("proposalHandler")
+	if err != nil {
+		return Channel{}, false, err
+	}
+	for i := 1; i < len(route); i++ {
+		if route[i].Channel != source.Name || route[i].Handler != handler {
+			continue
+		}
+		upstream, ok := r.channels[route[i-1].Channel]
+		if !ok || upstream.Handler != route[i-1].Handler {
+			return Channel{}, false, nil
+		}
+		return upstream, true, nil
+	}
+	return Channel{}, false, nil
+}
+
+//channelNames lists the channels of the router in a stable order
+func (r *Router) channelNames() []string {
+	names := []string{}
+	for name := range r.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//route reads the route of the proposal, which is empty if it has none
+func (proposal proposalEntry) route() ([]routeHop, error) {
+	route := []routeHop{}
+	routeAsBytes, ok := proposal.Proposal["route"]
+	if !ok {
+		return route, nil
+	}
+	err := json.Unmarshal(routeAsBytes, &route)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing route - %s", err.Error())
+	}
+	return route, nil
+}
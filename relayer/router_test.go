@@ -0,0 +1,89 @@
+package relayer
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+const testRoute = "[{\"channel\":\"one\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}," +
+	"{\"channel\":\"two\",\"proposalHandler\":\"Charlie\",\"expiry\":1500003600}," +
+	"{\"channel\":\"three\",\"proposalHandler\":\"Dave\",\"expiry\":1500000600}]"
+
+func TestNewRouterValidation(t *testing.T) {
+	one := Channel{Name: "one", Ledger: &fakeLedger{}, Handler: "Bob"}
+	invalid := map[string][]Channel{
+		"A router needs at least two channels.":       {one},
+		"A handler must be provided for channel two.": {one, {Name: "two", Ledger: &fakeLedger{}}},
+		"Channel one was provided more than once.":    {one, one},
+	}
+	for expected, channels := range invalid {
+		_, err := NewRouter(channels...)
+		if err == nil || err.Error() != expected {
+			t.Errorf("Router creation failed with %v, but expected: %s", err, expected)
+		}
+	}
+}
+
+func TestRouterForwardsToNextHop(t *testing.T) {
+	one := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"route\":" + testRoute + "},\"status\":\"PENDING\"," +
+			"\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}",
+		"prop2": "{\"proposal\":{\"proposalId\":\"prop2\",\"proposalHandler\":\"Bob\"},\"status\":\"PENDING\"," +
+			"\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}",
+	}}
+	two := &fakeLedger{}
+	r, err := NewRouter(Channel{Name: "one", Ledger: one, Handler: "Bob"}, Channel{Name: "two", Ledger: two, Handler: "Bob"})
+	if err != nil {
+		t.Fatalf("Router creation failed - %s", err.Error())
+	}
+	//Only the routed proposal is forwarded, as the expiry is set by the route
+	payload := "{\"events\":[" +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}," +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop2\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}]}"
+	err = r.HandleEvent("one", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Router failed to handle event - %s", err.Error())
+	}
+	expected := "createProposal({\"counterpartChannel\":\"one\",\"counterpartProposalId\":\"prop1\",\"proposalHandler\":\"Charlie\"," +
+		"\"proposalId\":\"prop1\",\"route\":" + testRoute + "},hash,SHA256,{\"retry\":true})"
+	if len(two.invoked) != 1 || two.invoked[0] != expected {
+		t.Errorf("Router invoked %v, but expected %s.", two.invoked, expected)
+	}
+}
+
+func TestRouterNotConnectedToNextHop(t *testing.T) {
+	//The router handles the second hop, but isn't connected to the third
+	two := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Charlie\",\"route\":" + testRoute + "},\"status\":\"PENDING\"," +
+			"\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500003600}",
+	}}
+	r, err := NewRouter(Channel{Name: "one", Ledger: &fakeLedger{}, Handler: "Charlie"}, Channel{Name: "two", Ledger: two, Handler: "Charlie"})
+	if err != nil {
+		t.Fatalf("Router creation failed - %s", err.Error())
+	}
+	payload := "{\"events\":[{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Charlie\",\"expiry\":1500003600}]}"
+	err = r.HandleEvent("two", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err == nil || !strings.HasSuffix(err.Error(), "The router isn't connected to channel three, the next hop of the route.") {
+		t.Errorf("Router handled event with error %v, but expected it to report the missing channel.", err)
+	}
+}
+
+func TestRelayerLeavesRoutedProposals(t *testing.T) {
+	one := &fakeLedger{proposals: map[string]string{
+		"prop1": "{\"proposal\":{\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"route\":" + testRoute + "},\"status\":\"PENDING\"," +
+			"\"hash\":\"hash\",\"hashAlgorithm\":\"SHA256\",\"expiry\":1500007200}",
+	}}
+	two := &fakeLedger{}
+	r := newFakeRelayer(t, one, two)
+	payload := "{\"events\":[{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500007200}]}"
+	err := r.HandleEvent("one", ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Relayer failed to handle event - %s", err.Error())
+	}
+	if len(two.invoked) != 0 {
+		t.Errorf("Relayer invoked %v, but expected routed proposals to be left to a router.", two.invoked)
+	}
+}
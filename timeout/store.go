@@ -0,0 +1,68 @@
+package timeout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+//Store persists the schedule of deadlines, as unix seconds keyed by
+//proposalId, so that it survives restarts
+type Store interface {
+	Load() (map[string]int64, error)
+	Save(schedule map[string]int64) error
+}
+
+//FileStore keeps the schedule as a JSON document in a local file. Saves are
+//written to a temporary file which then replaces the original, so a crash
+//never leaves a partially written schedule.
+type FileStore struct {
+	Path string
+}
+
+//Load reads the schedule, which is empty if nothing has been saved yet
+func (store FileStore) Load() (map[string]int64, error) {
+	schedule := make(map[string]int64)
+	scheduleAsBytes, err := ioutil.ReadFile(store.Path)
+	if os.IsNotExist(err) {
+		return schedule, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error reading schedule - %s", err.Error())
+	}
+	err = json.Unmarshal(scheduleAsBytes, &schedule)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing schedule - %s", err.Error())
+	}
+	return schedule, nil
+}
+
+//Save replaces the stored schedule
+func (store FileStore) Save(schedule map[string]int64) error {
+	scheduleAsBytes, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("Error building schedule - %s", err.Error())
+	}
+	file, err := ioutil.TempFile(filepath.Dir(store.Path), filepath.Base(store.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error creating schedule - %s", err.Error())
+	}
+	_, err = file.Write(scheduleAsBytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), store.Path)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("Error writing schedule - %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,318 @@
+/*
+ * Timeout service, which invalidates proposals once their timelock expires.
+ * Deadlines are taken from the TIMEOUT_REGISTRATION events fired when
+ * proposals are created, and kept in a Store. On start, the pending proposals
+ * are re-scanned from the ledger, to pick up anything missed while it was
+ * down.
+ *
+ * The identity used must be configured as a timeout service in the access
+ * policy of the contract.
+ */
+
+package timeout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+//Errors returned by invalidateProposal which the watcher handles
+const (
+	notPendingMessage = "Only pending proposals can be timed out."
+	noProposalMessage = "No such proposal."
+	notExpiredMessage = "The timelock on this proposal has not yet expired."
+)
+
+//conflictMarkers identify transactions which failed validation because they
+//raced another transaction, and can be retried
+var conflictMarkers = []string{"MVCC_READ_CONFLICT", "PHANTOM_READ_CONFLICT"}
+
+//openStatuses are the statuses of proposals which can still time out
+var openStatuses = []string{"PENDING", "ACCEPTED"}
+
+//queryPageSize is the number of proposals requested per page when scanning
+const queryPageSize = 100
+
+//proposalQuery mirrors the filter passed to queryProposals
+type proposalQuery struct {
+	Status   string `json:"status"`
+	PageSize int    `json:"pageSize"`
+	Bookmark string `json:"bookmark,omitempty"`
+}
+
+//proposalQueryResponse mirrors a page of results from queryProposals
+type proposalQueryResponse struct {
+	Proposals []struct {
+		Proposal struct {
+			ProposalID string `json:"proposalId"`
+		} `json:"proposal"`
+		Expiry int64 `json:"expiry"`
+	} `json:"proposals"`
+	Bookmark string `json:"bookmark"`
+}
+
+//Config controls when the watcher invalidates proposals. Grace is added to
+//every expiry, and should be at least the clock skew tolerance configured on
+//the contract. A random delay of up to Jitter is added on top, so that
+//several watchers don't all invalidate at once. Transactions failing on a
+//read conflict are retried up to Retries times, RetryDelay apart. Deadlines
+//are checked every PollInterval.
+type Config struct {
+	Grace        time.Duration
+	Jitter       time.Duration
+	Retries      int
+	RetryDelay   time.Duration
+	PollInterval time.Duration
+}
+
+//Watcher invalidates proposals on a single channel
+type Watcher struct {
+	ledger   ledger.Ledger
+	store    Store
+	config   Config
+	schedule map[string]int64
+	random   *rand.Rand
+	//now and sleep can be replaced in tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+//New creates a watcher which invalidates proposals through the ledger,
+//keeping its schedule in the store
+func New(channelLedger ledger.Ledger, store Store, config Config) (*Watcher, error) {
+	if channelLedger == nil || store == nil {
+		return nil, errors.New("Both a ledger and a store must be provided.")
+	}
+	if config.Grace < 0 || config.Jitter < 0 || config.Retries < 0 || config.RetryDelay < 0 {
+		return nil, errors.New("The grace, jitter, retries and retry delay cannot be negative.")
+	}
+	if config.PollInterval <= 0 {
+		return nil, errors.New("The poll interval must be positive.")
+	}
+	return &Watcher{
+		ledger:   channelLedger,
+		store:    store,
+		config:   config,
+		schedule: make(map[string]int64),
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:      time.Now,
+		sleep:    time.Sleep,
+	}, nil
+}
+
+//Run watches for proposals until the context is cancelled. Failures to
+//invalidate are logged, and retried on the next poll.
+func (w *Watcher) Run(ctx context.Context) error {
+	//Subscribe before scanning, see ledger.Ledger
+	delivered, err := w.ledger.Events(ctx)
+	if err != nil {
+		return fmt.Errorf("Error subscribing to events - %s", err.Error())
+	}
+	err = w.Start()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(w.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-delivered:
+			if !ok {
+				return errors.New("The event stream closed.")
+			}
+			err = w.HandleEvent(event)
+			if err != nil {
+				log.Printf("Error handling transaction %s - %s", event.TxID, err.Error())
+			}
+		case <-ticker.C:
+			err = w.InvalidateDue()
+			if err != nil {
+				log.Printf("Error invalidating proposals - %s", err.Error())
+			}
+		}
+	}
+}
+
+//Start loads the stored schedule, then reconciles it against the pending
+//proposals on the ledger
+func (w *Watcher) Start() error {
+	schedule, err := w.store.Load()
+	if err != nil {
+		return err
+	}
+	w.schedule = schedule
+	return w.Rescan()
+}
+
+//Rescan schedules every pending or accepted proposal on the ledger which
+//isn't already scheduled, and drops scheduled proposals which are neither
+func (w *Watcher) Rescan() error {
+	pending := make(map[string]bool)
+	for _, status := range openStatuses {
+		query := proposalQuery{Status: status, PageSize: queryPageSize}
+		for {
+			queryAsBytes, err := json.Marshal(query)
+			if err != nil {
+				return fmt.Errorf("Error building query - %s", err.Error())
+			}
+			responseAsBytes, err := w.ledger.Query("queryProposals", string(queryAsBytes))
+			if err != nil {
+				return fmt.Errorf("Error querying pending proposals - %s", err.Error())
+			}
+			response := proposalQueryResponse{}
+			err = json.Unmarshal(responseAsBytes, &response)
+			if err != nil {
+				return fmt.Errorf("Error parsing pending proposals - %s", err.Error())
+			}
+			for _, proposal := range response.Proposals {
+				proposalID := proposal.Proposal.ProposalID
+				pending[proposalID] = true
+				if _, ok := w.schedule[proposalID]; !ok {
+					w.schedule[proposalID] = w.deadline(proposal.Expiry)
+				}
+			}
+			if response.Bookmark == "" {
+				break
+			}
+			query.Bookmark = response.Bookmark
+		}
+	}
+	for proposalID := range w.schedule {
+		if !pending[proposalID] {
+			delete(w.schedule, proposalID)
+		}
+	}
+	return w.store.Save(w.schedule)
+}
+
+//HandleEvent schedules newly created proposals, and drops those which have
+//been settled
+func (w *Watcher) HandleEvent(event ledger.Event) error {
+	if event.Name != events.Name {
+		return nil
+	}
+	envelope, err := events.Decode(event.Payload)
+	if err != nil {
+		return err
+	}
+	changed := false
+	for _, subEvent := range envelope.Events {
+		switch subEvent.Type {
+		case events.TimeoutRegistration:
+			if _, ok := w.schedule[subEvent.ProposalID]; !ok {
+				w.schedule[subEvent.ProposalID] = w.deadline(subEvent.Expiry)
+				changed = true
+			}
+		case events.Confirmation, events.Invalidation, events.Rejection:
+			if _, ok := w.schedule[subEvent.ProposalID]; ok {
+				delete(w.schedule, subEvent.ProposalID)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return w.store.Save(w.schedule)
+}
+
+//InvalidateDue invalidates every proposal whose deadline has passed, oldest
+//first. Every proposal is attempted, and the first failure is returned.
+func (w *Watcher) InvalidateDue() error {
+	now := w.now().Unix()
+	due := []string{}
+	for proposalID, deadline := range w.schedule {
+		if deadline <= now {
+			due = append(due, proposalID)
+		}
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return w.schedule[due[i]] < w.schedule[due[j]]
+	})
+	var firstErr error
+	for _, proposalID := range due {
+		err := w.invalidate(proposalID)
+		switch {
+		case err == nil, hasMessage(err, notPendingMessage), hasMessage(err, noProposalMessage):
+			delete(w.schedule, proposalID)
+		case hasMessage(err, notExpiredMessage):
+			//Our clock is ahead of the transaction timestamps, so try again later
+			w.schedule[proposalID] = w.now().Add(w.config.Grace).Unix()
+		default:
+			if firstErr == nil {
+				firstErr = fmt.Errorf("Error invalidating proposal %s - %s", proposalID, err.Error())
+			}
+		}
+	}
+	err := w.store.Save(w.schedule)
+	if firstErr == nil {
+		firstErr = err
+	}
+	return firstErr
+}
+
+//invalidate submits invalidateProposal, retrying on read conflicts
+func (w *Watcher) invalidate(proposalID string) error {
+	var err error
+	for attempt := 0; attempt <= w.config.Retries; attempt++ {
+		if attempt > 0 {
+			w.sleep(w.config.RetryDelay + w.jitter())
+		}
+		_, err = w.ledger.Invoke("invalidateProposal", proposalID)
+		if err == nil || !isConflict(err) {
+			return err
+		}
+	}
+	return err
+}
+
+//deadline works out when a proposal with the expiry should be invalidated
+func (w *Watcher) deadline(expiry int64) int64 {
+	return time.Unix(expiry, 0).Add(w.config.Grace + w.jitter()).Unix()
+}
+
+//jitter is a random delay of up to the configured jitter
+func (w *Watcher) jitter() time.Duration {
+	if w.config.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(w.random.Int63n(int64(w.config.Jitter)))
+}
+
+//Scheduled returns the deadline for a proposal, and whether it is scheduled
+func (w *Watcher) Scheduled(proposalID string) (time.Time, bool) {
+	deadline, ok := w.schedule[proposalID]
+	return time.Unix(deadline, 0), ok
+}
+
+//hasMessage checks whether the error returned by the ledger carries the
+//message of the contract
+func hasMessage(err error, message string) bool {
+	return err != nil && strings.Contains(err.Error(), message)
+}
+
+//isConflict checks whether a transaction failed because it raced another,
+//see conflictMarkers
+func isConflict(err error) bool {
+	for _, marker := range conflictMarkers {
+		if hasMessage(err, marker) {
+			return true
+		}
+	}
+	return false
+}
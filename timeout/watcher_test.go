@@ -0,0 +1,168 @@
+package timeout
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CallanHP/hlf-htla-proof-of-concept/events"
+	"github.com/CallanHP/hlf-htla-proof-of-concept/ledger"
+)
+
+const testTime int64 = 1500000000
+
+//fakeLedger serves a fixed page of pending proposals, and fails invocations
+//with queued errors
+type fakeLedger struct {
+	pending string
+	errs    []error
+	invoked []string
+}
+
+func (l *fakeLedger) Events(ctx context.Context) (<-chan ledger.Event, error) {
+	return make(chan ledger.Event), nil
+}
+
+func (l *fakeLedger) Invoke(function string, args ...string) ([]byte, error) {
+	l.invoked = append(l.invoked, function+"("+args[0]+")")
+	if len(l.errs) > 0 {
+		err := l.errs[0]
+		l.errs = l.errs[1:]
+		return nil, err
+	}
+	return nil, nil
+}
+
+func (l *fakeLedger) Query(function string, args ...string) ([]byte, error) {
+	if l.pending == "" {
+		return []byte("{\"proposals\":[],\"bookmark\":\"\"}"), nil
+	}
+	return []byte(l.pending), nil
+}
+
+func newTestWatcher(t *testing.T, channelLedger *fakeLedger, store Store) *Watcher {
+	w, err := New(channelLedger, store, Config{Grace: 5 * time.Minute, Retries: 2, RetryDelay: time.Second, PollInterval: time.Second})
+	if err != nil {
+		t.Fatalf("Watcher creation failed - %s", err.Error())
+	}
+	w.now = func() time.Time { return time.Unix(testTime, 0) }
+	w.sleep = func(time.Duration) {}
+	return w
+}
+
+func newTestStore(t *testing.T) (FileStore, func()) {
+	dir, err := ioutil.TempDir("", "timeout")
+	if err != nil {
+		t.Fatalf("Error creating store directory - %s", err.Error())
+	}
+	return FileStore{Path: filepath.Join(dir, "schedule.json")}, func() { os.RemoveAll(dir) }
+}
+
+func TestScheduleSurvivesRestart(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	channelLedger := &fakeLedger{}
+	w := newTestWatcher(t, channelLedger, store)
+	err := w.Start()
+	if err != nil {
+		t.Fatalf("Watcher failed to start - %s", err.Error())
+	}
+	payload := "{\"events\":[" +
+		"{\"type\":\"HANDLER_NOTIFICATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500003600}," +
+		"{\"type\":\"TIMEOUT_REGISTRATION\",\"proposalId\":\"prop1\",\"proposalHandler\":\"Bob\",\"expiry\":1500003600}]}"
+	err = w.HandleEvent(ledger.Event{Name: events.Name, Payload: []byte(payload)})
+	if err != nil {
+		t.Fatalf("Watcher failed to handle event - %s", err.Error())
+	}
+	deadline, ok := w.Scheduled("prop1")
+	if !ok || deadline.Unix() != testTime+60*60+5*60 {
+		t.Errorf("Watcher scheduled prop1 at %d, but expected %d.", deadline.Unix(), testTime+60*60+5*60)
+	}
+
+	//A new watcher picks up the stored schedule, as long as it is still pending
+	channelLedger.pending = "{\"proposals\":[{\"proposal\":{\"proposalId\":\"prop1\"},\"expiry\":1500003600}],\"bookmark\":\"\"}"
+	restarted := newTestWatcher(t, channelLedger, store)
+	err = restarted.Start()
+	if err != nil {
+		t.Fatalf("Watcher failed to restart - %s", err.Error())
+	}
+	restartedDeadline, ok := restarted.Scheduled("prop1")
+	if !ok || restartedDeadline != deadline {
+		t.Errorf("Restarted watcher scheduled prop1 at %d, but expected %d.", restartedDeadline.Unix(), deadline.Unix())
+	}
+}
+
+func TestRescanPicksUpMissedProposals(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	err := store.Save(map[string]int64{"settled": testTime})
+	if err != nil {
+		t.Fatalf("Error saving schedule - %s", err.Error())
+	}
+	channelLedger := &fakeLedger{pending: "{\"proposals\":[{\"proposal\":{\"proposalId\":\"missed\"},\"expiry\":1500003600}],\"bookmark\":\"\"}"}
+	w := newTestWatcher(t, channelLedger, store)
+	err = w.Start()
+	if err != nil {
+		t.Fatalf("Watcher failed to start - %s", err.Error())
+	}
+	if _, ok := w.Scheduled("missed"); !ok {
+		t.Error("Watcher didn't schedule the pending proposal.")
+	}
+	if _, ok := w.Scheduled("settled"); ok {
+		t.Error("Watcher kept a proposal which is no longer pending.")
+	}
+}
+
+func TestInvalidateDueRetriesConflicts(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	channelLedger := &fakeLedger{errs: []error{errors.New("transaction returned with failure: MVCC_READ_CONFLICT")}}
+	w := newTestWatcher(t, channelLedger, store)
+	w.schedule = map[string]int64{"due": testTime - 1, "later": testTime + 1}
+	err := w.InvalidateDue()
+	if err != nil {
+		t.Fatalf("Watcher failed to invalidate - %s", err.Error())
+	}
+	if len(channelLedger.invoked) != 2 || channelLedger.invoked[1] != "invalidateProposal(due)" {
+		t.Errorf("Watcher invoked %v, but expected invalidateProposal(due) twice.", channelLedger.invoked)
+	}
+	if _, ok := w.Scheduled("due"); ok {
+		t.Error("Watcher kept an invalidated proposal scheduled.")
+	}
+	if _, ok := w.Scheduled("later"); !ok {
+		t.Error("Watcher dropped a proposal which wasn't due.")
+	}
+	stored, err := store.Load()
+	if err != nil || len(stored) != 1 {
+		t.Errorf("Watcher stored %v, but expected only the later proposal.", stored)
+	}
+}
+
+func TestInvalidateDueOutcomes(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	channelLedger := &fakeLedger{errs: []error{
+		errors.New("Only pending proposals can be timed out."),
+		errors.New("The timelock on this proposal has not yet expired."),
+		errors.New("connection refused"),
+	}}
+	w := newTestWatcher(t, channelLedger, store)
+	w.schedule = map[string]int64{"settled": testTime - 3, "early": testTime - 2, "unreachable": testTime - 1}
+	err := w.InvalidateDue()
+	if err == nil {
+		t.Error("Watcher didn't report the failed invalidation.")
+	}
+	if _, ok := w.Scheduled("settled"); ok {
+		t.Error("Watcher kept a settled proposal scheduled.")
+	}
+	if deadline, ok := w.Scheduled("early"); !ok || deadline.Unix() != testTime+5*60 {
+		t.Errorf("Watcher rescheduled early proposal at %d, but expected %d.", deadline.Unix(), testTime+5*60)
+	}
+	if deadline, ok := w.Scheduled("unreachable"); !ok || deadline.Unix() != testTime-1 {
+		t.Error("Watcher didn't keep the unreachable proposal due.")
+	}
+}